@@ -3,10 +3,20 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	cloudflare "github.com/cloudflare/cloudflare-go"
 	"github.com/pkg/errors"
@@ -21,128 +31,1547 @@ const (
 	RequestProtoIP6
 )
 
-func getCurrentIP(ipEndpoint string, proto RequestProto) (netip.Addr, error) {
-	req, err := http.NewRequest("GET", ipEndpoint, nil)
-	if err != nil {
-		return netip.Addr{}, errors.Wrap(err, "could not create the request to the IP provider")
+// String returns the name of the family, "ip4", "ip6" or "ip" for any.
+func (p RequestProto) String() string {
+	switch p {
+	case RequestProtoIP4:
+		return "ip4"
+	case RequestProtoIP6:
+		return "ip6"
 	}
+	return "ip"
+}
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				var d net.Dialer
-				switch proto {
-				case RequestProtoIP4:
-					d.FallbackDelay = -1
-					if strings.HasPrefix(network, "tcp") {
-						network = "tcp4"
-					}
-					if strings.HasPrefix(network, "udp") {
-						network = "udp4"
-					}
-				case RequestProtoIP6:
-					d.FallbackDelay = -1
-					if strings.HasPrefix(network, "tcp") {
-						network = "tcp6"
-					}
-					if strings.HasPrefix(network, "udp") {
-						network = "udp6"
-					}
-				}
-				return d.DialContext(ctx, network, addr)
-			},
+// The errors of the lookups of the zone and the records, they can be told apart
+// with errors.Is.
+var (
+	ErrZoneNotFound    = stderrors.New("zone not found")
+	ErrRecordNotFound  = stderrors.New("record not found")
+	ErrMultipleRecords = stderrors.New("multiple records found")
+	// ErrRecordLocked is returned for the records that are locked or managed
+	// by another integration, i.e. Email Routing or a Tunnel.
+	ErrRecordLocked = stderrors.New("record is locked or managed by another integration")
+	// ErrEmptyResponse is returned for the providers that responded with an
+	// empty body or a blank first line.
+	ErrEmptyResponse = stderrors.New("provider returned an empty response")
+)
+
+// isRecordLocked reports whether the Cloudflare API rejected a change as the
+// record is locked or managed by another integration. The API doesn't have a
+// single error code for it, so the messages of the rejected requests (4xx
+// other than 404 and 429) are matched. The typed request and authentication
+// errors are only made for those statuses, the bare errors are checked.
+func isRecordLocked(err error) bool {
+	if errors.Is(err, ErrRecordLocked) {
+		return true
+	}
+
+	// The typed errors made without the inner error panic on every method.
+	var messages []string
+	var requestErr *cloudflare.RequestError
+	var authErr *cloudflare.AuthenticationError
+	var apiErr *cloudflare.Error
+	switch {
+	case errors.As(err, &requestErr):
+		if *requestErr == (cloudflare.RequestError{}) {
+			return false
+		}
+		messages = requestErr.ErrorMessages()
+	case errors.As(err, &authErr):
+		if *authErr == (cloudflare.AuthenticationError{}) {
+			return false
+		}
+		messages = authErr.ErrorMessages()
+	case errors.As(err, &apiErr):
+		if apiErr.StatusCode < 400 || apiErr.StatusCode >= 500 || apiErr.StatusCode == 404 || apiErr.StatusCode == 429 {
+			return false
+		}
+		messages = apiErr.ErrorMessages
+	}
+	for _, msg := range messages {
+		msg = strings.ToLower(msg)
+		if strings.Contains(msg, "locked") || strings.Contains(msg, "managed by") || strings.Contains(msg, "read only") || strings.Contains(msg, "read-only") {
+			return true
+		}
+	}
+	return false
+}
+
+// lockedRecordError logs what to do about the locked record and returns err
+// wrapped with ErrRecordLocked.
+func lockedRecordError(ctx context.Context, record cloudflare.DNSRecord, err error) error {
+	logFrom(ctx).WithError(err).WithFields(logrus.Fields{
+		"event":     "record_locked",
+		"name":      record.Name,
+		"type":      record.Type,
+		"record_id": record.ID,
+	}).Error("the record is locked or managed by another integration, remove it from the config or change it where it's managed")
+	if errors.Is(err, ErrRecordLocked) {
+		return err
+	}
+	return errors.Wrapf(ErrRecordLocked, "%s record %s: %v", record.Type, record.Name, err)
+}
+
+// dnsAPI is the part of the Cloudflare API the records are updated with, it's
+// implemented by *cloudflare.API.
+type dnsAPI interface {
+	ListDNSRecords(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error)
+	GetDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, recordID string) (cloudflare.DNSRecord, error)
+	CreateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.CreateDNSRecordParams) (cloudflare.DNSRecord, error)
+	UpdateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.UpdateDNSRecordParams) (cloudflare.DNSRecord, error)
+	DeleteDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, recordID string) error
+}
+
+var _ dnsAPI = (*cloudflare.API)(nil)
+
+// ipProviderTimeout limits how long a single request to an IP provider may take,
+// it is configured from the command line flags.
+var ipProviderTimeout = 10 * time.Second
+
+// dialFallbackDelay is how long the IPv6 connection to a provider of any
+// family gets before IPv4 is tried as well (Happy Eyeballs). Zero is the
+// default of Go and a negative delay disables the fallback. It's configured
+// from the command line flags.
+var dialFallbackDelay time.Duration
+
+// maxProviderBodySize limits how much of the IP provider response is read.
+const maxProviderBodySize = 4 << 10
+
+// defaultTTL is used for newly created records; 1 means "automatic".
+const defaultTTL = 1
+
+// validateTTL accepts 1 (automatic) or a TTL within the range allowed by Cloudflare.
+func validateTTL(ttl int) error {
+	if ttl == defaultTTL || ttl >= 60 && ttl <= 86400 {
+		return nil
+	}
+	return errors.Errorf("invalid ttl %d, must be 1 (automatic) or between 60 and 86400", ttl)
+}
+
+// bindOptions select the local interface or address the requests to the IP
+// providers are made from.
+type bindOptions struct {
+	Interface string
+	Address   netip.Addr
+}
+
+// proxyFamilyWarning makes sure that the proxy is only warned about once.
+var proxyFamilyWarning sync.Once
+
+// newIPProviderTransport returns the transport that dials the IP providers
+// over the given family from the bound interface or address. The proxy of
+// the environment (HTTP_PROXY, HTTPS_PROXY and NO_PROXY) is honored, but then
+// only the connection to the proxy is of the family and the provider sees
+// the address the proxy connects from.
+func newIPProviderTransport(proto RequestProto, bind bindOptions) http.RoundTripper {
+	return &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			proxy, err := http.ProxyFromEnvironment(req)
+			if proxy != nil && proto != RequestProtoDefault {
+				proxyFamilyWarning.Do(func() {
+					logFrom(req.Context()).WithFields(logrus.Fields{
+						"event": "ip_provider_proxy",
+						"proxy": proxy.Redacted(),
+					}).Warn("the ip providers are requested through a proxy, the detected address is the one of the proxy and may be of the other family")
+				})
+			}
+			return proxy, err
+		},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			if bind.Address.IsValid() {
+				d.LocalAddr = &net.TCPAddr{IP: bind.Address.AsSlice()}
+			}
+			if bind.Interface != "" {
+				d.Control = bindToDevice(bind.Interface)
+			}
+			if proto != RequestProtoDefault {
+				// There's nothing to fall back to with a single family.
+				d.FallbackDelay = -1
+			} else {
+				d.FallbackDelay = dialFallbackDelay
+			}
+			dialNetwork := networkForProto(network, proto)
+			logFrom(ctx).WithFields(logrus.Fields{
+				"event":   "ip_provider_dial",
+				"addr":    addr,
+				"network": dialNetwork,
+			}).Debug("dialing the ip provider")
+			return d.DialContext(ctx, dialNetwork, addr)
 		},
 	}
+}
+
+// networkForProto returns the network to dial for the requested family, i.e.
+// "tcp4" for "tcp" and RequestProtoIP4. The networks other than tcp and udp
+// and the RequestProtoDefault requests are left as they are.
+func networkForProto(network string, proto RequestProto) string {
+	var family string
+	switch proto {
+	case RequestProtoIP4:
+		family = "4"
+	case RequestProtoIP6:
+		family = "6"
+	default:
+		return network
+	}
+	for _, base := range []string{"tcp", "udp"} {
+		if strings.HasPrefix(network, base) {
+			return base + family
+		}
+	}
+	return network
+}
+
+// newIPProviderClient returns the client of the requests to the IP providers.
+// A nil transport defaults to newIPProviderTransport, an empty userAgent
+// keeps the one of Go.
+func newIPProviderClient(proto RequestProto, bind bindOptions, transport http.RoundTripper, userAgent string) *http.Client {
+	if transport == nil {
+		transport = newIPProviderTransport(proto, bind)
+	}
+	if userAgent != "" {
+		transport = userAgentTransport{UserAgent: userAgent, Base: transport}
+	}
+	return &http.Client{
+		Timeout:   ipProviderTimeout,
+		Transport: transport,
+	}
+}
+
+// userAgentTransport sets the User-Agent of the requests, some providers
+// reject the default one of Go.
+type userAgentTransport struct {
+	UserAgent string
+	Base      http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.UserAgent)
+	return t.Base.RoundTrip(req)
+}
+
+func getCurrentIP(ctx context.Context, client *http.Client, endpoint ProviderSpec, proto RequestProto) (netip.Addr, error) {
+	// Remember the family of the connection the response came over.
+	var network string
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			network = "tcp6"
+			if addr, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok && addr.IP.To4() != nil {
+				network = "tcp4"
+			}
+		},
+	})
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint.URL, nil)
+	if err != nil {
+		return netip.Addr{}, errors.Wrap(err, "could not create the request to the IP provider")
+	}
+
 	res, err := client.Do(req)
 	if err != nil {
 		return netip.Addr{}, errors.Wrap(err, "current ip http req failed")
 	}
 	defer res.Body.Close()
 
-	s := bufio.NewScanner(res.Body)
-	if !s.Scan() {
-		return netip.Addr{}, errors.Wrap(s.Err(), "no output from the provider")
-	}
+	s := bufio.NewScanner(io.LimitReader(res.Body, maxProviderBodySize))
+	s.Scan()
+	line := strings.TrimSpace(s.Text())
+	contentType := res.Header.Get("Content-Type")
+	logFrom(ctx).WithFields(logrus.Fields{
+		"event":        "ip_provider_response",
+		"endpoint":     endpoint.URL,
+		"url":          res.Request.URL.String(),
+		"status":       res.StatusCode,
+		"network":      network,
+		"content_type": contentType,
+		"body":         truncate(line, 64),
+	}).Debug("ip provider response")
 
-	ip, err := netip.ParseAddr(s.Text())
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return netip.Addr{}, errors.Wrap(newHTTPStatusError(res), "current ip http req failed")
+	}
+	if err := s.Err(); err != nil {
+		return netip.Addr{}, errors.Wrap(err, "could not read the response of the provider")
+	}
+	format := endpoint.Format
+	if format == "" {
+		format = formatText
+		switch {
+		case isCloudflareTrace(res.Request.URL.Path):
+			format = formatTrace
+		case strings.Contains(contentType, "json") || strings.HasPrefix(line, "{"):
+			format = formatJSON
+		}
+	}
+	var ip netip.Addr
+	switch format {
+	case formatTrace, formatJSON:
+		body := line
+		for s.Scan() {
+			body += "\n" + s.Text()
+		}
+		if err := s.Err(); err != nil {
+			return netip.Addr{}, errors.Wrap(err, "could not read the response of the provider")
+		}
+		if strings.TrimSpace(body) == "" {
+			return netip.Addr{}, ErrEmptyResponse
+		}
+		if format == formatTrace {
+			ip, err = parseCloudflareTrace(body)
+		} else {
+			path := endpoint.Path
+			if path == "" {
+				path = defaultJSONPath
+			}
+			ip, err = parseProviderJSON(body, path)
+		}
+	default:
+		ip, err = parseProviderOutput(line, contentType)
+	}
 	if err != nil {
-		return netip.Addr{}, errors.Wrap(err, "failed to parse ip")
+		return netip.Addr{}, err
 	}
 
+	// Some providers answer the IPv4 requests with an IPv4-mapped IPv6
+	// address, i.e. ::ffff:203.0.113.5, which is never the address of an AAAA
+	// record anyway.
+	if ip.Is4In6() {
+		logFrom(ctx).WithFields(logrus.Fields{
+			"event":    "ip_unmapped",
+			"endpoint": endpoint.URL,
+			"ip":       ip,
+		}).Debug("the provider returned an IPv4-mapped address, using the IPv4 address")
+		ip = ip.Unmap()
+	}
 	if proto == RequestProtoIP4 && !ip.Is4() || proto == RequestProtoIP6 && !ip.Is6() {
 		return netip.Addr{}, errors.Errorf("ip addr family mismatch %v", ip)
 	}
 	return ip, nil
 }
 
-func updateRecord(ctx context.Context, api *cloudflare.API, zone, domainName, recordType, content string) error {
-	zoneID, err := api.ZoneIDByName(zone)
+// parseProviderOutput parses the first line of the response of a provider
+// with the given content type. It's fed the untrusted remote input, so junk
+// must always result in an error.
+func parseProviderOutput(line, contentType string) (netip.Addr, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return netip.Addr{}, ErrEmptyResponse
+	}
+
+	if strings.HasPrefix(line, "<") {
+		return netip.Addr{}, errors.Errorf("provider returned markup instead of an ip (content-type %q): %q", contentType, truncate(line, 64))
+	}
+
+	ip, err := parseProviderAddr(line)
+	if err != nil {
+		if strings.Contains(contentType, "html") {
+			return netip.Addr{}, errors.Wrapf(err, "provider returned html instead of an ip: %q", truncate(line, 64))
+		}
+		return netip.Addr{}, errors.Wrap(err, "failed to parse ip")
+	}
+	// A zone only makes sense for the local addresses.
+	if ip.Zone() != "" {
+		return netip.Addr{}, errors.Errorf("provider returned an address with a zone: %q", truncate(line, 64))
+	}
+	return ip, nil
+}
+
+// isCloudflareTrace reports whether the path is of the Cloudflare trace
+// endpoint, i.e. https://1.1.1.1/cdn-cgi/trace.
+func isCloudflareTrace(path string) bool {
+	return strings.HasSuffix(path, "/cdn-cgi/trace")
+}
+
+// parseCloudflareTrace extracts the address from the key=value lines of the
+// response of the Cloudflare trace endpoint.
+func parseCloudflareTrace(body string) (netip.Addr, error) {
+	for _, line := range strings.Split(body, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if ok && key == "ip" {
+			return parseProviderOutput(value, "text/plain")
+		}
+	}
+	return netip.Addr{}, errors.New("no ip in the cloudflare trace")
+}
+
+// parseProviderJSON extracts the address at the dot separated path of keys and
+// array indexes from the json response of a provider.
+func parseProviderJSON(body, path string) (netip.Addr, error) {
+	var v any
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		return netip.Addr{}, errors.Wrapf(err, "provider returned invalid json: %q", truncate(body, 64))
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			v = node[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return netip.Addr{}, errors.Errorf("no %s in the json response of the provider", path)
+			}
+			v = node[i]
+		default:
+			v = nil
+		}
+		if v == nil {
+			return netip.Addr{}, errors.Errorf("no %s in the json response of the provider", path)
+		}
+	}
+	s, ok := v.(string)
+	if !ok {
+		return netip.Addr{}, errors.Errorf("%s in the json response of the provider isn't a string", path)
+	}
+	return parseProviderOutput(s, "text/plain")
+}
+
+// parseProviderAddr parses the address returned by a provider, some of them
+// return it in the CIDR notation, i.e. 203.0.113.5/32.
+func parseProviderAddr(s string) (netip.Addr, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		return prefix.Addr(), nil
+	}
+	return netip.ParseAddr(s)
+}
+
+// truncate shortens s to at most n bytes for logging.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+func getCurrentIPWithFallback(ctx context.Context, client *http.Client, endpoints []ProviderSpec, proto RequestProto) (netip.Addr, error) {
+	if len(endpoints) == 0 {
+		return netip.Addr{}, errors.New("no ip providers configured")
+	}
+
+	// Skip the providers with an open circuit unless all of them are open.
+	now := time.Now()
+	allowed := slices.DeleteFunc(slices.Clone(endpoints), func(endpoint ProviderSpec) bool {
+		return !providerBreaker.allow(endpoint.URL, proto, now)
+	})
+	if len(allowed) == 0 {
+		logFrom(ctx).WithField("event", "ip_provider_circuits_open").Warn("the circuits of all ip providers are open, trying them anyway")
+		allowed = endpoints
+	} else if skipped := len(endpoints) - len(allowed); skipped > 0 {
+		logFrom(ctx).WithFields(logrus.Fields{
+			"event":   "ip_provider_skipped",
+			"skipped": skipped,
+		}).Debug("skipping ip providers with an open circuit")
+	}
+
+	var errs []error
+	for _, endpoint := range allowed {
+		ip, err := getCurrentIP(ctx, client, endpoint, proto)
+		if err == nil {
+			providerBreaker.success(endpoint.URL, proto)
+			return ip, nil
+		}
+		if ctx.Err() == nil {
+			providerBreaker.failure(endpoint.URL, proto, time.Now())
+		}
+		logFrom(ctx).WithError(err).WithFields(logrus.Fields{
+			"event":    "ip_provider_failed",
+			"endpoint": endpoint.URL,
+		}).Warn("ip provider failed")
+		errs = append(errs, errors.Wrap(err, endpoint.URL))
+	}
+	return netip.Addr{}, errors.Wrap(stderrors.Join(errs...), "all ip providers failed")
+}
+
+// getCurrentIPConsensus returns the address only once two providers agree on it.
+func getCurrentIPConsensus(ctx context.Context, client *http.Client, endpoints []ProviderSpec, proto RequestProto) (netip.Addr, error) {
+	var ip netip.Addr
+	var agreed int
+	var errs []error
+	for _, endpoint := range endpoints {
+		got, err := getCurrentIP(ctx, client, endpoint, proto)
+		if err != nil {
+			logFrom(ctx).WithError(err).WithFields(logrus.Fields{
+				"event":    "ip_provider_failed",
+				"endpoint": endpoint.URL,
+			}).Warn("ip provider failed")
+			errs = append(errs, errors.Wrap(err, endpoint.URL))
+			continue
+		}
+		if agreed > 0 && got != ip {
+			return netip.Addr{}, errors.Errorf("ip providers disagree: %v != %v", ip, got)
+		}
+		ip = got
+		agreed++
+		if agreed == 2 {
+			return ip, nil
+		}
+	}
+	return netip.Addr{}, errors.Errorf("need two ip providers to agree, got %d answers: %v", agreed, stderrors.Join(errs...))
+}
+
+// ipProvider describes where the current IP address is fetched from.
+type ipProvider struct {
+	Source IPSource
+	// IPv6Suffix replaces the host bits after IPv6PrefixLen of the detected
+	// IPv6 address when valid.
+	IPv6Suffix    netip.Addr
+	IPv6PrefixLen int
+}
+
+func (p ipProvider) currentIP(ctx context.Context, proto RequestProto) (netip.Addr, error) {
+	defer func(start time.Time) {
+		ddnsMetrics.observeIPFetch(time.Since(start))
+	}(time.Now())
+
+	ip, err := p.Source.GetIP(ctx, proto)
+	if err != nil || !p.IPv6Suffix.IsValid() || !ip.Is6() {
+		return ip, err
+	}
+
+	suffixed, err := withIPv6Suffix(ip, p.IPv6PrefixLen, p.IPv6Suffix)
 	if err != nil {
-		return errors.Wrap(err, "could not find zone by name")
+		return netip.Addr{}, err
 	}
+	logFrom(ctx).WithFields(logrus.Fields{
+		"event":    "ipv6_suffix_applied",
+		"detected": ip,
+		"ip6":      suffixed,
+	}).Debug("applied the ipv6 suffix")
+	return suffixed, nil
+}
+
+// updateOptions controls how updateRecord treats the record.
+type updateOptions struct {
+	// CreateIfMissing creates the record if it doesn't exist yet.
+	CreateIfMissing bool
+	// TTL of the record, 1 means automatic. Zero keeps the current TTL and
+	// uses defaultTTL for created records.
+	TTL int
+	// Proxied forces the proxied state of the record, nil keeps the current
+	// state or the Cloudflare default for created records.
+	Proxied *bool
+	// Cache skips the Cloudflare API when the content was already pushed.
+	Cache *lastKnownIP
+	// MinChangeInterval suppresses a change of the record within this long of
+	// its last change in Cache, i.e. while a link fails over and back.
+	MinChangeInterval time.Duration
+	// DryRun looks the record up but only logs the change instead of making it.
+	DryRun bool
+	// VerifyDNS skips the A and AAAA records that already resolve to the
+	// address in the public DNS.
+	VerifyDNS bool
+	// Notifier is told about every change made to the record.
+	Notifier notifier
+	// Hooks are run before and after every change made to the record.
+	Hooks hookCommands
+	// Confirm asks the operator before every change, nil makes the changes
+	// without asking.
+	Confirm *confirmer
+	// Comment is the template of the comment set on the written records, empty
+	// keeps the current comment.
+	Comment string
+	// Tags replace the tags of the written records, nil keeps the current tags.
+	Tags []string
+	// UpdateAll updates every record matching the name and type instead of
+	// requiring a single one.
+	UpdateAll bool
+	// Concurrency is how many records UpdateRecords updates at the same time.
+	Concurrency int
+	// AllowPrivate allows pointing the records at private and other
+	// non-public addresses.
+	AllowPrivate bool
+	// AllowedCIDRs restricts the addresses of a family to the prefixes of that
+	// family, DeniedCIDRs rejects the addresses in the prefixes.
+	AllowedCIDRs []netip.Prefix
+	DeniedCIDRs  []netip.Prefix
+	// IPv6Optional skips the AAAA records without an error when there's no
+	// global IPv6 address, i.e. on networks where IPv6 comes and goes.
+	IPv6Optional bool
+	// ToggleProxy sets the proxied A and AAAA records to DNS only while their
+	// content is changed and proxies them again afterwards.
+	ToggleProxy bool
+	// GuardChanges refuses to move the A and AAAA records to an address in a
+	// different network than the current one.
+	GuardChanges bool
+	// Data is the structured data of the record types without a plain
+	// content like SRV, it's written instead of the content. Priority is the
+	// priority of the record.
+	Data     any
+	Priority *uint16
+	// Family restricts UpdateRecordsBySource to the records of one family,
+	// RequestProtoDefault updates all of them.
+	Family RequestProto
+	// RecordID is the ID of the record, it's fetched directly instead of
+	// listing the records of the name and type.
+	RecordID string
+	// ZoneName is the name of the zone of the records, the Writers look the
+	// zone up by it.
+	ZoneName string
+	// Writers are the additional DNS providers the records are written to
+	// after Cloudflare, i.e. a backup account.
+	Writers []secondaryWriter
+	// Step is told about the step of the update cycle in progress.
+	Step *cycleStep
+	// Summary counts the outcomes of the records of UpdateRecords.
+	Summary *updateSummary
+	// Writer is the name of the writer the records are written with, it
+	// tells the no change outcomes and the metrics of the writers apart.
+	// Empty is the primary Cloudflare writer.
+	Writer string
+}
+
+// writer returns the name of the writer of the records.
+func (opts updateOptions) writer() string {
+	if opts.Writer == "" {
+		return primaryWriter
+	}
+	return opts.Writer
+}
+
+// UpdateResult describes the outcome of a record update.
+type UpdateResult struct {
+	// Changed is set when the record was created or updated.
+	Changed bool
+	// Declined is set when the operator declined the change of a record.
+	Declined   bool
+	OldContent string
+	NewContent string
+	// RecordID is the ID of the record, the first changed one when several
+	// records were updated.
+	RecordID string
+}
 
-	dnsRecords, _, err := api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{
-		Name: domainName,
-		Type: recordType,
+func updateRecord(ctx context.Context, api dnsAPI, zoneID, domainName, recordType, content string, opts updateOptions) (result UpdateResult, err error) {
+	defer func() {
+		if err != nil {
+			ddnsMetrics.recordUpdate(opts.writer(), "error")
+		}
+		if err != nil || result.Changed {
+			noChangeLogs.reset(logFrom(ctx).WithFields(logrus.Fields{
+				"name": domainName,
+				"type": recordType,
+			}), noChangeKey(opts.writer(), recordType, domainName))
+		}
+	}()
+
+	if opts.Cache.Matches(recordType, domainName, content, opts.TTL, opts.Proxied) {
+		noChangeLogs.log(logFrom(ctx).WithFields(logrus.Fields{
+			"event":   "record_cached",
+			"name":    domainName,
+			"type":    recordType,
+			"content": content,
+		}), noChangeKey(opts.writer(), recordType, domainName), "no change since last update")
+		ddnsMetrics.recordUpdate(opts.writer(), "nochange")
+		return UpdateResult{OldContent: content, NewContent: content}, nil
+	}
+
+	if opts.MinChangeInterval > 0 {
+		// The records first seen with their content have no known change.
+		last, changedAt, ok := opts.Cache.LastChange(recordType, domainName)
+		if since := time.Since(changedAt); ok && !changedAt.IsZero() && since < opts.MinChangeInterval {
+			logFrom(ctx).WithFields(logrus.Fields{
+				"event":      "record_debounced",
+				"name":       domainName,
+				"type":       recordType,
+				"content":    last,
+				"new":        content,
+				"changed_at": changedAt,
+				"wait":       (opts.MinChangeInterval - since).Round(time.Second),
+			}).Info("debouncing the change, the record was changed too recently")
+			ddnsMetrics.recordUpdate(opts.writer(), "debounced")
+			return UpdateResult{OldContent: last, NewContent: last}, nil
+		}
+	}
+
+	if opts.VerifyDNS && (recordType == "A" || recordType == "AAAA") {
+		if liveDNSMatches(ctx, opts.writer(), domainName, recordType, content) {
+			ddnsMetrics.recordUpdate(opts.writer(), "nochange")
+			return UpdateResult{OldContent: content, NewContent: content}, nil
+		}
+	}
+
+	var dnsRecords []cloudflare.DNSRecord
+	if opts.RecordID != "" {
+		record, err := getRecordByID(ctx, api, zoneID, domainName, recordType, opts.RecordID)
+		if err != nil {
+			return UpdateResult{}, err
+		}
+		dnsRecords = []cloudflare.DNSRecord{record}
+	} else {
+		err = withRetry(ctx, defaultRetryPolicy, func() error {
+			var err error
+			dnsRecords, _, err = api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{
+				Name: domainName,
+				Type: recordType,
+			})
+			return err
+		})
+		if err != nil {
+			return UpdateResult{}, errors.Wrap(err, "error listing dns records for zone")
+		}
+	}
+
+	if len(dnsRecords) == 0 && opts.CreateIfMissing {
+		return createRecord(ctx, api, zoneID, domainName, recordType, content, opts)
+	}
+
+	if len(dnsRecords) == 0 {
+		return UpdateResult{}, errors.Wrapf(ErrRecordNotFound, "no %s record %s", recordType, domainName)
+	}
+	if len(dnsRecords) > 1 && !opts.UpdateAll {
+		return UpdateResult{}, errors.Wrapf(ErrMultipleRecords, "expected a single %s record %s, got %d", recordType, domainName, len(dnsRecords))
+	}
+
+	result = UpdateResult{
+		OldContent: dnsRecords[0].Content,
+		NewContent: content,
+		RecordID:   dnsRecords[0].ID,
+	}
+	var errs []error
+	apply := applyContent
+	if opts.ToggleProxy && (recordType == "A" || recordType == "AAAA") {
+		apply = applyContentWithProxyToggle
+	}
+	for _, record := range dnsRecords {
+		outcome, err := apply(ctx, api, zoneID, record, content, opts)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "record %s", record.ID))
+			continue
+		}
+		result.Declined = result.Declined || outcome == applyDeclined
+		if outcome == applyChanged && !result.Changed {
+			result.Changed = true
+			result.OldContent = record.Content
+			result.RecordID = record.ID
+		}
+	}
+	if len(errs) > 0 {
+		return result, stderrors.Join(errs...)
+	}
+
+	// The declined change isn't remembered so that it's proposed again.
+	if !opts.DryRun && !result.Declined {
+		contentChanged := result.Changed && !sameContent(recordType, result.OldContent, content)
+		rememberContent(ctx, opts, recordType, domainName, content, contentChanged)
+	}
+	return result, nil
+}
+
+// getRecordByID returns the record with the ID, which has to be the record of
+// the name and type. It only needs the permission to read the record, not to
+// list the records of the zone.
+func getRecordByID(ctx context.Context, api dnsAPI, zoneID, domainName, recordType, recordID string) (cloudflare.DNSRecord, error) {
+	var record cloudflare.DNSRecord
+	err := withRetry(ctx, defaultRetryPolicy, func() error {
+		var err error
+		record, err = api.GetDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), recordID)
+		return err
+	})
+	var notFound *cloudflare.NotFoundError
+	switch {
+	case errors.As(err, &notFound):
+		return cloudflare.DNSRecord{}, errors.Wrapf(ErrRecordNotFound, "no record with the id %s: %v", recordID, err)
+	case err != nil:
+		return cloudflare.DNSRecord{}, errors.Wrapf(err, "could not get the record %s", recordID)
+	case record.Type != recordType || !strings.EqualFold(record.Name, domainName):
+		return cloudflare.DNSRecord{}, errors.Errorf("the record %s is the %s record %s, not the %s record %s", recordID, record.Type, record.Name, recordType, domainName)
+	}
+	return record, nil
+}
+
+// createRecord creates the record of the name and type with content.
+func createRecord(ctx context.Context, api dnsAPI, zoneID, domainName, recordType, content string, opts updateOptions) (UpdateResult, error) {
+	ttl := opts.TTL
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	ttl = proxiedTTL(ctx, domainName, recordType, ttl, opts.Proxied)
+	if opts.DryRun {
+		logFrom(ctx).WithFields(logrus.Fields{
+			"event":   "record_would_create",
+			"name":    domainName,
+			"type":    recordType,
+			"content": content,
+		}).Infof("would create record %s with %s", domainName, content)
+		return UpdateResult{NewContent: content}, nil
+	}
+	if !opts.Confirm.confirm(changeEvent{Record: domainName, Type: recordType, NewIP: content}) {
+		logFrom(ctx).WithFields(logrus.Fields{
+			"event":   "record_change_declined",
+			"name":    domainName,
+			"type":    recordType,
+			"content": content,
+		}).Info("not creating the record")
+		return UpdateResult{Declined: true}, nil
+	}
+	err := opts.Hooks.runPre(ctx, changeEvent{
+		Record:    domainName,
+		Type:      recordType,
+		NewIP:     content,
+		Timestamp: time.Now(),
 	})
 	if err != nil {
-		return errors.Wrap(err, "error listing dns records for zone")
+		return UpdateResult{}, err
+	}
+	comment, err := renderComment(opts.Comment, time.Now())
+	if err != nil {
+		return UpdateResult{}, err
+	}
+	params := cloudflare.CreateDNSRecordParams{
+		Name:     domainName,
+		Type:     recordType,
+		Content:  content,
+		Data:     opts.Data,
+		Priority: opts.Priority,
+		TTL:      ttl,
+		Proxied:  opts.Proxied,
+		Comment:  comment,
+		Tags:     opts.Tags,
+	}
+	if opts.Data != nil {
+		// The content of the record is made from the data.
+		params.Content = ""
+	}
+	newRecord, err := api.CreateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), params)
+	if err != nil {
+		return UpdateResult{}, errors.Wrap(err, "could not create the DNS record")
+	}
+
+	logFrom(ctx).WithFields(logrus.Fields{
+		"event":   "record_created",
+		"name":    newRecord.Name,
+		"type":    newRecord.Type,
+		"content": newRecord.Content,
+	}).Info("created record")
+	ddnsMetrics.recordUpdate(opts.writer(), "changed")
+	rememberContent(ctx, opts, recordType, domainName, content, true)
+	event := changeEvent{
+		Record:    newRecord.Name,
+		Type:      newRecord.Type,
+		NewIP:     newRecord.Content,
+		Timestamp: time.Now(),
 	}
+	notifyChange(ctx, opts.Notifier, event)
+	opts.Hooks.runPost(ctx, event)
+	return UpdateResult{Changed: true, NewContent: newRecord.Content, RecordID: newRecord.ID}, nil
+}
 
-	if len(dnsRecords) != 1 {
-		return errors.Errorf("Expected to find a single dns record, got %d", len(dnsRecords))
+// sameContent reports whether the contents of the record are the same. The
+// addresses of the A and AAAA records are compared parsed, so that i.e.
+// 2001:DB8:0:0:0:0:0:1 and 2001:db8::1 are the same.
+func sameContent(recordType, a, b string) bool {
+	if recordType == "A" || recordType == "AAAA" {
+		ipA, errA := netip.ParseAddr(a)
+		ipB, errB := netip.ParseAddr(b)
+		if errA == nil && errB == nil {
+			return ipA == ipB
+		}
 	}
+	return a == b
+}
+
+// The prefix lengths of the network the new address of a record has to share
+// with the old one to pass the --ip-guard.
+const (
+	guardPrefixLen4 = 8
+	guardPrefixLen6 = 32
+)
+
+// guardChange returns an error when the new address of the A or AAAA record
+// is in a different network than the current one, i.e. because a proxy made
+// the provider report its own address. The other contents always pass.
+func guardChange(ctx context.Context, record cloudflare.DNSRecord, content string) error {
+	old, errOld := netip.ParseAddr(record.Content)
+	ip, errNew := netip.ParseAddr(content)
+	if errOld != nil || errNew != nil || old.Is4() != ip.Is4() {
+		return nil
+	}
+	bits := guardPrefixLen4
+	if ip.Is6() {
+		bits = guardPrefixLen6
+	}
+	network, _ := old.Prefix(bits)
+	if network.Contains(ip) {
+		return nil
+	}
+	logFrom(ctx).WithFields(logrus.Fields{
+		"event":   "ip_change_suspicious",
+		"name":    record.Name,
+		"type":    record.Type,
+		"old":     old,
+		"new":     ip,
+		"network": network,
+	}).Warn("the new address is in a different network than the current one, not updating the record")
+	return withExitCode(errors.Errorf("refusing to change %s record %s from %v to %v outside of %v, use --force to apply it", record.Type, record.Name, old, ip, network), ExitNetwork)
+}
+
+// proxiedTTL returns the TTL of the record that is or will be proxied, which
+// Cloudflare forces to automatic and rejects the explicit TTLs of.
+func proxiedTTL(ctx context.Context, domainName, recordType string, ttl int, proxied *bool) int {
+	if proxied == nil || !*proxied || ttl == defaultTTL {
+		return ttl
+	}
+	logFrom(ctx).WithFields(logrus.Fields{
+		"event": "ttl_overridden",
+		"name":  domainName,
+		"type":  recordType,
+		"ttl":   ttl,
+	}).Warn("the record is proxied, using the automatic ttl instead of the configured one")
+	return defaultTTL
+}
 
-	record := dnsRecords[0]
+// applyOutcome is what became of the change of a record.
+type applyOutcome int
 
-	if record.Content == content {
-		logrus.WithFields(logrus.Fields{
+const (
+	// applyUnchanged is the record that was already up to date.
+	applyUnchanged applyOutcome = iota
+	applyChanged
+	// applyDryRun is the change that was only logged.
+	applyDryRun
+	// applyDeclined is the change the operator declined, it's asked again
+	// next time.
+	applyDeclined
+)
+
+// applyContent updates the existing record to content unless it's already up
+// to date and reports what became of the change.
+func applyContent(ctx context.Context, api dnsAPI, zoneID string, record cloudflare.DNSRecord, content string, opts updateOptions) (applyOutcome, error) {
+	params, outcome, err := prepareChange(ctx, record, content, opts)
+	if err != nil || outcome != applyChanged {
+		return outcome, err
+	}
+	return writeChange(ctx, api, zoneID, record, params, opts)
+}
+
+// prepareChange returns the update of the record to content once it passed
+// the checks, the operator agreed to it and the pre hook ran. The update is
+// only to be made with writeChange when the outcome is applyChanged.
+func prepareChange(ctx context.Context, record cloudflare.DNSRecord, content string, opts updateOptions) (cloudflare.UpdateDNSRecordParams, applyOutcome, error) {
+	var params cloudflare.UpdateDNSRecordParams
+	proxied := record.Proxied
+	if opts.Proxied != nil {
+		proxied = opts.Proxied
+	}
+
+	ttl := record.TTL
+	if opts.TTL != 0 {
+		ttl = opts.TTL
+	}
+	ttl = proxiedTTL(ctx, record.Name, record.Type, ttl, proxied)
+
+	samePriority := opts.Priority == nil || record.Priority != nil && *record.Priority == *opts.Priority
+	if sameContent(record.Type, record.Content, content) && samePriority && ttl == record.TTL && (proxied == nil || record.Proxied != nil && *proxied == *record.Proxied) {
+		noChangeLogs.log(logFrom(ctx).WithFields(logrus.Fields{
+			"event":   "record_unchanged",
 			"name":    record.Name,
 			"type":    record.Type,
 			"content": record.Content,
-		}).Info("no change")
-		return nil
+		}), noChangeKey(opts.writer(), record.Type, record.Name), "no change")
+		ddnsMetrics.recordUpdate(opts.writer(), "nochange")
+		return params, applyUnchanged, nil
+	}
+
+	if record.Locked {
+		return params, applyUnchanged, lockedRecordError(ctx, record, errors.Wrapf(ErrRecordLocked, "%s record %s", record.Type, record.Name))
+	}
+	if opts.GuardChanges {
+		if err := guardChange(ctx, record, content); err != nil {
+			return params, applyUnchanged, err
+		}
+	}
+
+	if opts.DryRun {
+		logFrom(ctx).WithFields(logrus.Fields{
+			"event":   "record_would_update",
+			"name":    record.Name,
+			"type":    record.Type,
+			"content": content,
+		}).Infof("would update %s from %s to %s", record.Name, record.Content, content)
+		return params, applyDryRun, nil
 	}
 
-	newRecord, err := api.UpdateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.UpdateDNSRecordParams{
-		ID:      record.ID,
-		Name:    record.Name,
-		Type:    record.Type,
-		Content: content,
+	if !opts.Confirm.confirm(changeEvent{Record: record.Name, Type: record.Type, OldIP: record.Content, NewIP: content}) {
+		logFrom(ctx).WithFields(logrus.Fields{
+			"event":   "record_change_declined",
+			"name":    record.Name,
+			"type":    record.Type,
+			"content": record.Content,
+		}).Info("not updating the record")
+		return params, applyDeclined, nil
+	}
+
+	err := opts.Hooks.runPre(ctx, changeEvent{
+		Record:    record.Name,
+		Type:      record.Type,
+		OldIP:     record.Content,
+		NewIP:     content,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return params, applyUnchanged, err
+	}
+
+	// A nil comment keeps the current one, but the tags are always replaced.
+	var comment *string
+	if opts.Comment != "" {
+		c, err := renderComment(opts.Comment, time.Now())
+		if err != nil {
+			return params, applyUnchanged, err
+		}
+		comment = &c
+	}
+	tags := record.Tags
+	if opts.Tags != nil {
+		tags = opts.Tags
+	}
+
+	params = cloudflare.UpdateDNSRecordParams{
+		ID:       record.ID,
+		Name:     record.Name,
+		Type:     record.Type,
+		Content:  content,
+		Data:     opts.Data,
+		Priority: opts.Priority,
+		TTL:      ttl,
+		Proxied:  proxied,
+		Comment:  comment,
+		Tags:     tags,
+	}
+	if opts.Data != nil {
+		params.Content = ""
+	}
+	return params, applyChanged, nil
+}
+
+// writeChange makes the update of the record prepared with prepareChange.
+func writeChange(ctx context.Context, api dnsAPI, zoneID string, record cloudflare.DNSRecord, params cloudflare.UpdateDNSRecordParams, opts updateOptions) (applyOutcome, error) {
+	var newRecord cloudflare.DNSRecord
+	err := withRetry(ctx, defaultRetryPolicy, func() error {
+		var err error
+		newRecord, err = api.UpdateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), params)
+		return err
 	})
+	if isRecordLocked(err) {
+		return applyUnchanged, lockedRecordError(ctx, record, err)
+	}
 	if err != nil {
-		return errors.Wrap(err, "could not update the DNS record")
+		return applyUnchanged, errors.Wrap(err, "could not update the DNS record")
 	}
 
 	// Log the update.
-	logrus.WithFields(logrus.Fields{
+	logFrom(ctx).WithFields(logrus.Fields{
+		"event":   "record_updated",
 		"name":    newRecord.Name,
 		"type":    newRecord.Type,
 		"content": newRecord.Content,
+		"ttl":     newRecord.TTL,
+		"proxied": newRecord.Proxied != nil && *newRecord.Proxied,
 	}).Info("updated record")
-	return nil
+	ddnsMetrics.recordUpdate(opts.writer(), "changed")
+	event := changeEvent{
+		Record:    newRecord.Name,
+		Type:      newRecord.Type,
+		OldIP:     record.Content,
+		NewIP:     newRecord.Content,
+		Timestamp: time.Now(),
+	}
+	notifyChange(ctx, opts.Notifier, event)
+	opts.Hooks.runPost(ctx, event)
+	return applyChanged, nil
 }
 
-func UpdateDomain4(ctx context.Context, api *cloudflare.API, zone, domainName, ipEndpoint string) error {
-	ip, err := getCurrentIP(ipEndpoint, RequestProtoIP4)
+// liveDNSMatches reports whether the record already resolves to content in the
+// public DNS. Lookup failures are logged and treated as a mismatch.
+func liveDNSMatches(ctx context.Context, writer, domainName, recordType, content string) bool {
+	// Only the A and AAAA records are verified.
+	proto, _ := protoForRecordType(recordType)
+	log := logFrom(ctx).WithFields(logrus.Fields{
+		"name": domainName,
+		"type": recordType,
+	})
+
+	ip, err := resolveCurrent(ctx, domainName, proto)
 	if err != nil {
-		return errors.Wrap(err, "could not get the current IP4 address")
+		log.WithError(err).WithField("event", "live_dns_failed").Warn("could not verify the live dns")
+		return false
 	}
-	logrus.WithField("ip", ip).Info("got current IP4 address")
-	if err := updateRecord(ctx, api, zone, domainName, "A", ip.String()); err != nil {
-		return errors.Wrap(err, "failed to update A record")
+	if !sameContent(recordType, ip.String(), content) {
+		log.WithFields(logrus.Fields{
+			"event": "live_dns_differs",
+			"live":  ip,
+		}).Debug("live dns differs")
+		return false
 	}
-	return nil
+	noChangeLogs.log(log.WithFields(logrus.Fields{
+		"event":   "live_dns_matches",
+		"content": content,
+	}), noChangeKey(writer, recordType, domainName), "live dns already matches")
+	return true
+}
+
+// rememberContent stores the pushed content and the settings it was written
+// with in the cache, failing to persist it is not fatal for the update. A
+// change is logged with how long the record held the previous content, i.e.
+// to tell how often the ISP changes it.
+func rememberContent(ctx context.Context, opts updateOptions, recordType, domainName, content string, changed bool) {
+	cache := opts.Cache
+	last, changedAt, ok := cache.LastChange(recordType, domainName)
+	if err := cache.Set(recordType, domainName, content, opts.TTL, opts.Proxied, changed); err != nil {
+		logFrom(ctx).WithError(err).WithField("event", "state_save_failed").Warn("could not save the state")
+	}
+	// The address is held by the record, not by each of the writers.
+	if opts.writer() != primaryWriter || !ok || changedAt.IsZero() || sameContent(recordType, last, content) {
+		return
+	}
+
+	held := time.Since(changedAt)
+	ddnsMetrics.observeIPHeld(held)
+	logFrom(ctx).WithFields(logrus.Fields{
+		"event":        "ip_held",
+		"name":         domainName,
+		"type":         recordType,
+		"old":          last,
+		"new":          content,
+		"held_seconds": int64(held.Seconds()),
+	}).Infof("%s changed after holding %s for %s", domainName, last, formatHeld(held))
+}
+
+// formatHeld formats the duration in days and hours, i.e. 14d3h, or in
+// minutes and seconds when it's shorter than an hour.
+func formatHeld(d time.Duration) string {
+	if d < time.Hour {
+		return d.Round(time.Second).String()
+	}
+	hours := int64(d / time.Hour)
+	if hours < 24 {
+		return fmt.Sprintf("%dh%dm", hours, int64(d/time.Minute)%60)
+	}
+	return fmt.Sprintf("%dd%dh", hours/24, hours%24)
+}
+
+// UpdateRecordContent sets the content of the record of any type, i.e. a
+// TXT or a CNAME record.
+func UpdateRecordContent(ctx context.Context, api dnsAPI, zoneID, name, recordType, content string, opts updateOptions) error {
+	_, err := writeSpec(ctx, api, zoneID, RecordSpec{Name: name, Type: recordType}, content, opts)
+	return err
+}
+
+func UpdateDomain4(ctx context.Context, api dnsAPI, zoneID, domainName string, provider ipProvider, opts updateOptions) error {
+	_, err := UpdateDomain4Result(ctx, api, zoneID, domainName, provider, opts)
+	return err
+}
+
+// UpdateDomain4Result is UpdateDomain4 that also returns the outcome of the update.
+func UpdateDomain4Result(ctx context.Context, api dnsAPI, zoneID, domainName string, provider ipProvider, opts updateOptions) (UpdateResult, error) {
+	ip, err := provider.currentIP(ctx, RequestProtoIP4)
+	if err != nil {
+		return UpdateResult{}, errors.Wrap(err, "could not get the current IP4 address")
+	}
+	if err := checkAddr(ctx, ip, opts); err != nil {
+		return UpdateResult{}, err
+	}
+	logFrom(ctx).WithFields(logrus.Fields{
+		"event": "ip_detected",
+		"ip":    ip,
+	}).Info("got current IP4 address")
+	return updateSpec(ctx, api, zoneID, RecordSpec{Name: domainName, Type: "A"}, ip, netip.Addr{}, opts)
+}
+
+func UpdateDomain6(ctx context.Context, api dnsAPI, zoneID, domainName string, provider ipProvider, opts updateOptions) error {
+	_, err := UpdateDomain6Result(ctx, api, zoneID, domainName, provider, opts)
+	return err
 }
 
-func UpdateDomain6(ctx context.Context, api *cloudflare.API, zone, domainName, ipEndpoint string) error {
-	ip, err := getCurrentIP(ipEndpoint, RequestProtoIP6)
+// UpdateDomain6Result is UpdateDomain6 that also returns the outcome of the update.
+func UpdateDomain6Result(ctx context.Context, api dnsAPI, zoneID, domainName string, provider ipProvider, opts updateOptions) (UpdateResult, error) {
+	ip, err := provider.currentIP(ctx, RequestProtoIP6)
+	if err == nil {
+		err = checkAddr(ctx, ip, opts)
+	} else {
+		err = errors.Wrap(err, "could not get the current IP6 address")
+	}
 	if err != nil {
-		return errors.Wrap(err, "could not get the current IP6 address")
+		if opts.IPv6Optional {
+			skipIPv6(ctx, domainName, err)
+			return UpdateResult{}, nil
+		}
+		return UpdateResult{}, err
+	}
+	logFrom(ctx).WithFields(logrus.Fields{
+		"event": "ip_detected",
+		"ip6":   ip,
+	}).Info("got current IP6 address")
+	return updateSpec(ctx, api, zoneID, RecordSpec{Name: domainName, Type: "AAAA"}, netip.Addr{}, ip, opts)
+}
+
+// UpdateDomainAuto detects the address without forcing a family and updates
+// the A or the AAAA record of the domain depending on the one it got.
+func UpdateDomainAuto(ctx context.Context, api dnsAPI, zoneID, domainName string, provider ipProvider, opts updateOptions) error {
+	_, err := UpdateDomainAutoResult(ctx, api, zoneID, domainName, provider, opts)
+	return err
+}
+
+// UpdateDomainAutoResult is UpdateDomainAuto that also returns the outcome of the update.
+func UpdateDomainAutoResult(ctx context.Context, api dnsAPI, zoneID, domainName string, provider ipProvider, opts updateOptions) (UpdateResult, error) {
+	ip, err := provider.currentIP(ctx, RequestProtoDefault)
+	if err != nil {
+		return UpdateResult{}, errors.Wrap(err, "could not get the current IP address")
+	}
+	if err := checkAddr(ctx, ip, opts); err != nil {
+		return UpdateResult{}, err
+	}
+	recordType := recordTypeFor(ip)
+	logFrom(ctx).WithFields(logrus.Fields{
+		"event": "ip_detected",
+		"ip":    ip,
+		"type":  recordType,
+	}).Info("got current IP address")
+	var ip4, ip6 netip.Addr
+	if ip.Is4() {
+		ip4 = ip
+	} else {
+		ip6 = ip
+	}
+	return updateSpec(ctx, api, zoneID, RecordSpec{Name: domainName, Type: recordType}, ip4, ip6, opts)
+}
+
+// checkAddr rejects a private, loopback, link-local or otherwise unroutable
+// address that would break the record unless opts.AllowPrivate is set, and an
+// address outside of opts.AllowedCIDRs or inside of opts.DeniedCIDRs. The
+// invalid address passes, it means no address of that family.
+func checkAddr(ctx context.Context, ip netip.Addr, opts updateOptions) error {
+	if !ip.IsValid() {
+		return nil
 	}
-	logrus.WithField("ip6", ip).Info("got current IP6 address")
-	if err := updateRecord(ctx, api, zone, domainName, "AAAA", ip.String()); err != nil {
-		return errors.Wrap(err, "failed to update AAAA record")
+	if !opts.AllowPrivate && !isPublicAddr(ip) {
+		return errors.Errorf("refusing to point the records at the non-public address %v, use --allow-private to allow it", ip)
+	}
+
+	for _, prefix := range opts.DeniedCIDRs {
+		if prefix.Contains(ip) {
+			logFrom(ctx).WithFields(logrus.Fields{
+				"event": "ip_denied",
+				"ip":    ip,
+				"rule":  prefix,
+			}).Warn("detected address is in a denied cidr, skipping the update")
+			return errors.Errorf("address %v is in the denied cidr %v", ip, prefix)
+		}
+	}
+
+	// The allowed CIDRs of the other family don't restrict the address.
+	var allowed []netip.Prefix
+	for _, prefix := range opts.AllowedCIDRs {
+		if prefix.Addr().Is4() == ip.Is4() {
+			allowed = append(allowed, prefix)
+		}
+	}
+	if len(allowed) > 0 && !slices.ContainsFunc(allowed, func(prefix netip.Prefix) bool { return prefix.Contains(ip) }) {
+		logFrom(ctx).WithFields(logrus.Fields{
+			"event": "ip_denied",
+			"ip":    ip,
+			"rule":  allowed,
+		}).Warn("detected address is not in any allowed cidr, skipping the update")
+		return errors.Errorf("address %v is not in any of the allowed cidrs %v", ip, allowed)
 	}
 	return nil
 }
+
+// protoForRecordType returns the family of the address the record of the type
+// points at, the auto records point at an address of any family. The other
+// types don't point at an address.
+func protoForRecordType(recordType string) (RequestProto, error) {
+	switch recordType {
+	case "A":
+		return RequestProtoIP4, nil
+	case "AAAA":
+		return RequestProtoIP6, nil
+	case "auto":
+		return RequestProtoDefault, nil
+	}
+	return RequestProtoDefault, errors.Errorf("%s records don't point at an ip address", recordType)
+}
+
+// recordTypeFor returns the type of the record pointing at ip.
+func recordTypeFor(ip netip.Addr) string {
+	if ip.Is4() {
+		return "A"
+	}
+	return "AAAA"
+}
+
+// UpdateDomainDualStack updates both the A and AAAA records of the domain at
+// the same time. A failure of one of them doesn't prevent the other one from
+// being updated.
+func UpdateDomainDualStack(ctx context.Context, api dnsAPI, zoneID, domainName string, ip4, ip6 ipProvider, opts updateOptions) error {
+	var err6 error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err6 = UpdateDomain6(ctx, api, zoneID, domainName, ip6, opts)
+	}()
+	err4 := UpdateDomain4(ctx, api, zoneID, domainName, ip4, opts)
+	wg.Wait()
+	return stderrors.Join(err4, err6)
+}
+
+// UpdateRecords points all of the records at the given addresses, A records at
+// ip4 and AAAA records at ip6, other records get their rendered content.
+// Records that need an address of a family without a valid one are skipped.
+// Up to opts.Concurrency records are updated at the same time and a failure to
+// update one record doesn't stop the others from being updated.
+func UpdateRecords(ctx context.Context, api dnsAPI, zoneID string, specs []RecordSpec, ip4, ip6 netip.Addr, opts updateOptions) error {
+	err4 := checkAddr(ctx, ip4, opts)
+	if err4 != nil {
+		ip4 = netip.Addr{}
+	}
+	err6 := checkAddr(ctx, ip6, opts)
+	if err6 != nil {
+		ip6 = netip.Addr{}
+		if opts.IPv6Optional {
+			skipIPv6(ctx, "", err6)
+			err6 = nil
+		}
+	}
+
+	opts.Summary.setIP(ip4)
+	opts.Summary.setIP(ip6)
+
+	errs := make([]error, len(specs), len(specs)+2)
+	sem := make(chan struct{}, max(opts.Concurrency, 1))
+	var wg sync.WaitGroup
+	for i, spec := range specs {
+		if spec.needsIP(RequestProtoIP6) && !ip6.IsValid() && opts.IPv6Optional && !spec.needsIP(RequestProtoIP4) {
+			continue
+		}
+		if spec.needsIP(RequestProtoIP4) && !ip4.IsValid() || spec.needsIP(RequestProtoIP6) && !ip6.IsValid() {
+			// The address of the family couldn't be detected.
+			opts.Summary.add(false, errSkipped)
+			continue
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, spec RecordSpec) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			// Every line logged for the record carries its name and type.
+			ctx := withLog(ctx, logFrom(ctx).WithFields(logrus.Fields{
+				"record": spec.Name,
+				"type":   spec.Type,
+			}))
+			var result UpdateResult
+			result, errs[i] = updateSpec(ctx, api, zoneID, spec, ip4, ip6, opts)
+			opts.Summary.add(result.Changed, errs[i])
+		}(i, spec)
+	}
+	wg.Wait()
+	return stderrors.Join(append(errs, err4, err6)...)
+}
+
+// skipIPv6 logs the AAAA records of the domain, or all of them, being skipped
+// since there's no global IPv6 address in the IPv6Optional mode.
+func skipIPv6(ctx context.Context, domainName string, err error) {
+	log := logFrom(ctx).WithError(err).WithField("event", "ipv6_skipped")
+	if domainName != "" {
+		log = log.WithField("domain", domainName)
+	}
+	log.Debug("no global IP6 address, skipping the AAAA records")
+}
+
+// errSkipped counts the records that weren't updated for a lack of an address.
+var errSkipped = stderrors.New("skipped")
+
+func updateSpec(ctx context.Context, api dnsAPI, zoneID string, spec RecordSpec, ip4, ip6 netip.Addr, opts updateOptions) (UpdateResult, error) {
+	content, err := spec.render(ip4, ip6)
+	if err != nil {
+		return UpdateResult{}, err
+	}
+
+	return writeSpec(ctx, api, zoneID, spec, content, opts)
+}
+
+// writeSpec writes the rendered content of the record with the primary writer
+// and the secondary ones.
+func writeSpec(ctx context.Context, api dnsAPI, zoneID string, spec RecordSpec, content string, opts updateOptions) (UpdateResult, error) {
+	opts = spec.options(opts)
+	opts.RecordID = spec.ID
+	if spec.SRV != nil {
+		opts.Data = spec.SRV.data()
+		opts.Priority = &spec.SRV.Priority
+	}
+	primary := cloudflareWriter{name: primaryWriter, api: api, zoneID: zoneID}
+	result, err := writeRecord(ctx, primary, opts.ZoneName, spec.Name, spec.Type, content, opts)
+	return result, errors.Wrapf(err, "failed to update %s record %s", spec.Type, spec.Name)
+}
+
+// filterFamily drops the records that need an address of the other family
+// than family, the auto records become the records of family.
+func filterFamily(ctx context.Context, specs []RecordSpec, family RequestProto) []RecordSpec {
+	if family == RequestProtoDefault {
+		return specs
+	}
+	other := RequestProtoIP6
+	if family == RequestProtoIP6 {
+		other = RequestProtoIP4
+	}
+
+	filtered := make([]RecordSpec, 0, len(specs))
+	for _, spec := range specs {
+		if spec.needsIP(other) {
+			logFrom(ctx).WithFields(logrus.Fields{
+				"event": "record_filtered",
+				"name":  spec.Name,
+				"type":  spec.Type,
+			}).Debug("skipping the record of the other family")
+			continue
+		}
+		if spec.Type == "auto" {
+			spec.Type = "A"
+			if family == RequestProtoIP6 {
+				spec.Type = "AAAA"
+			}
+		}
+		filtered = append(filtered, spec)
+	}
+	return filtered
+}
+
+// sourceProviders are the providers of the addresses of one IP source. Any
+// accepts the address of either family.
+type sourceProviders struct {
+	IP4, IP6, Any ipProvider
+}
+
+// UpdateRecordsBySource groups the records by their IP source, detects the
+// addresses of each source once and updates the records of the group with
+// UpdateRecords. The records without a source use defaultSource. The "auto"
+// records become A or AAAA records depending on the family of the address the
+// source returns without forcing one. The records with several sources are
+// reconciled with updateRoundRobin.
+func UpdateRecordsBySource(ctx context.Context, api dnsAPI, zoneID string, specs []RecordSpec, defaultSource string, providers map[string]sourceProviders, opts updateOptions) error {
+	specs = filterFamily(ctx, specs, opts.Family)
+
+	var errs []error
+	var sources []string
+	groups := map[string][]RecordSpec{}
+	for _, spec := range specs {
+		if len(spec.Sources) > 0 {
+			opts.Step.set("updating the round-robin records " + spec.Name)
+			ctx := withLog(ctx, logFrom(ctx).WithFields(logrus.Fields{
+				"record": spec.Name,
+				"type":   spec.Type,
+			}))
+			result, err := updateRoundRobin(ctx, api, zoneID, spec, providers, opts)
+			opts.Summary.add(result.Changed, err)
+			errs = append(errs, errors.Wrapf(err, "failed to update %s records %s", spec.Type, spec.Name))
+			continue
+		}
+		source := spec.Source
+		if source == "" {
+			source = defaultSource
+		}
+		if _, ok := groups[source]; !ok {
+			sources = append(sources, source)
+		}
+		groups[source] = append(groups[source], spec)
+	}
+
+	for _, source := range sources {
+		group := groups[source]
+		p := providers[source]
+		log := logFrom(ctx).WithField("source", source)
+		ctx := withLog(ctx, log)
+
+		// Both of the families are detected at the same time so that a slow
+		// provider of one doesn't delay the other, and a failure of one
+		// doesn't prevent the records of the other from being updated.
+		var ip4, ip6 netip.Addr
+		var err4, err6 error
+		var wg sync.WaitGroup
+		opts.Step.set("detecting the IP addresses with " + source)
+		if slices.ContainsFunc(group, func(r RecordSpec) bool { return r.needsIP(RequestProtoIP4) }) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ip4, err4 = p.IP4.currentIP(ctx, RequestProtoIP4)
+			}()
+		}
+		if slices.ContainsFunc(group, func(r RecordSpec) bool { return r.needsIP(RequestProtoIP6) }) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ip6, err6 = p.IP6.currentIP(ctx, RequestProtoIP6)
+			}()
+		}
+		wg.Wait()
+
+		if err4 != nil {
+			errs = append(errs, withExitCode(errors.Wrap(err4, "could not get the current IP4 address"), ExitNetwork))
+		} else if ip4.IsValid() {
+			log.WithFields(logrus.Fields{
+				"event": "ip_detected",
+				"ip":    ip4,
+			}).Info("got current IP4 address")
+		}
+		if err6 != nil {
+			err6 = errors.Wrap(err6, "could not get the current IP6 address")
+			if opts.IPv6Optional {
+				skipIPv6(ctx, "", err6)
+			} else {
+				errs = append(errs, withExitCode(err6, ExitNetwork))
+			}
+		} else if ip6.IsValid() {
+			log.WithFields(logrus.Fields{
+				"event": "ip_detected",
+				"ip6":   ip6,
+			}).Info("got current IP6 address")
+		}
+
+		var auto []RecordSpec
+		fixed := make([]RecordSpec, 0, len(group))
+		for _, spec := range group {
+			if spec.needsIP(RequestProtoDefault) {
+				auto = append(auto, spec)
+			} else {
+				fixed = append(fixed, spec)
+			}
+		}
+		if len(auto) > 0 {
+			opts.Step.set("detecting the IP address with " + source)
+			ip, err := p.Any.currentIP(ctx, RequestProtoDefault)
+			if err != nil {
+				errs = append(errs, withExitCode(errors.Wrap(err, "could not get the current IP address"), ExitNetwork))
+			} else {
+				recordType := recordTypeFor(ip)
+				log.WithFields(logrus.Fields{
+					"event": "ip_detected",
+					"ip":    ip,
+					"type":  recordType,
+				}).Info("got current IP address")
+
+				var autoIP4, autoIP6 netip.Addr
+				if recordType == "A" {
+					autoIP4 = ip
+				} else {
+					autoIP6 = ip
+				}
+				for i := range auto {
+					auto[i].Type = recordType
+				}
+				opts.Step.set(fmt.Sprintf("updating %d record(s)", len(auto)))
+				errs = append(errs, UpdateRecords(ctx, api, zoneID, auto, autoIP4, autoIP6, opts))
+			}
+		}
+
+		opts.Step.set(fmt.Sprintf("updating %d record(s)", len(fixed)))
+		errs = append(errs, UpdateRecords(ctx, api, zoneID, fixed, ip4, ip6, opts))
+	}
+	return stderrors.Join(errs...)
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	stderrors "errors"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"testing"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/pkg/errors"
+)
+
+// fakeDNS is a dnsAPI over the records of a single zone.
+type fakeDNS struct {
+	records   []cloudflare.DNSRecord
+	listErr   error
+	updateErr error
+
+	lists   int
+	updates []cloudflare.UpdateDNSRecordParams
+	creates []cloudflare.CreateDNSRecordParams
+	deletes []string
+}
+
+func (f *fakeDNS) ListDNSRecords(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error) {
+	f.lists++
+	if f.listErr != nil {
+		return nil, nil, f.listErr
+	}
+	var records []cloudflare.DNSRecord
+	for _, r := range f.records {
+		if (params.Name == "" || r.Name == params.Name) && (params.Type == "" || r.Type == params.Type) {
+			records = append(records, r)
+		}
+	}
+	return records, &cloudflare.ResultInfo{}, nil
+}
+
+func (f *fakeDNS) GetDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, recordID string) (cloudflare.DNSRecord, error) {
+	for _, r := range f.records {
+		if r.ID == recordID {
+			return r, nil
+		}
+	}
+	err := cloudflare.NewNotFoundError(&cloudflare.Error{StatusCode: 404, ErrorMessages: []string{"Record not found"}})
+	return cloudflare.DNSRecord{}, &err
+}
+
+func (f *fakeDNS) CreateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.CreateDNSRecordParams) (cloudflare.DNSRecord, error) {
+	f.creates = append(f.creates, params)
+	return cloudflare.DNSRecord{ID: "created", Name: params.Name, Type: params.Type, Content: params.Content, TTL: params.TTL, Proxied: params.Proxied}, nil
+}
+
+func (f *fakeDNS) UpdateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.UpdateDNSRecordParams) (cloudflare.DNSRecord, error) {
+	if f.updateErr != nil {
+		return cloudflare.DNSRecord{}, f.updateErr
+	}
+	f.updates = append(f.updates, params)
+	return cloudflare.DNSRecord{ID: params.ID, Name: params.Name, Type: params.Type, Content: params.Content, TTL: params.TTL, Proxied: params.Proxied}, nil
+}
+
+func (f *fakeDNS) DeleteDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, recordID string) error {
+	f.deletes = append(f.deletes, recordID)
+	return nil
+}
+
+func aRecord(id, content string) cloudflare.DNSRecord {
+	return cloudflare.DNSRecord{ID: id, Name: "home.example.com", Type: "A", Content: content, TTL: 1, Proxied: cloudflare.BoolPtr(false)}
+}
+
+func TestUpdateRecord(t *testing.T) {
+	errAPI := stderrors.New("api error")
+	tests := []struct {
+		name    string
+		api     *fakeDNS
+		opts    updateOptions
+		want    UpdateResult
+		wantErr error
+		updates int
+		creates int
+	}{
+		{
+			name: "no change",
+			api:  &fakeDNS{records: []cloudflare.DNSRecord{aRecord("1", "203.0.113.7")}},
+			want: UpdateResult{OldContent: "203.0.113.7", NewContent: "203.0.113.7", RecordID: "1"},
+		},
+		{
+			name:    "change",
+			api:     &fakeDNS{records: []cloudflare.DNSRecord{aRecord("1", "198.51.100.1")}},
+			want:    UpdateResult{Changed: true, OldContent: "198.51.100.1", NewContent: "203.0.113.7", RecordID: "1"},
+			updates: 1,
+		},
+		{
+			name:    "missing record",
+			api:     &fakeDNS{},
+			wantErr: ErrRecordNotFound,
+		},
+		{
+			name:    "missing record created",
+			api:     &fakeDNS{},
+			opts:    updateOptions{CreateIfMissing: true},
+			want:    UpdateResult{Changed: true, NewContent: "203.0.113.7", RecordID: "created"},
+			creates: 1,
+		},
+		{
+			name:    "multiple records",
+			api:     &fakeDNS{records: []cloudflare.DNSRecord{aRecord("1", "198.51.100.1"), aRecord("2", "198.51.100.2")}},
+			wantErr: ErrMultipleRecords,
+		},
+		{
+			name:    "multiple records updated",
+			api:     &fakeDNS{records: []cloudflare.DNSRecord{aRecord("1", "203.0.113.7"), aRecord("2", "198.51.100.2")}},
+			opts:    updateOptions{UpdateAll: true},
+			want:    UpdateResult{Changed: true, OldContent: "198.51.100.2", NewContent: "203.0.113.7", RecordID: "2"},
+			updates: 1,
+		},
+		{
+			name:    "list error",
+			api:     &fakeDNS{listErr: errAPI},
+			wantErr: errAPI,
+		},
+		{
+			name:    "update error",
+			api:     &fakeDNS{records: []cloudflare.DNSRecord{aRecord("1", "198.51.100.1")}, updateErr: errAPI},
+			want:    UpdateResult{OldContent: "198.51.100.1", NewContent: "203.0.113.7", RecordID: "1"},
+			wantErr: errAPI,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := updateRecord(context.Background(), tt.api, "zone", "home.example.com", "A", "203.0.113.7", tt.opts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("updateRecord() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("updateRecord() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("updateRecord() = %+v, want %+v", got, tt.want)
+			}
+			if len(tt.api.updates) != tt.updates {
+				t.Errorf("updateRecord() made %d updates, want %d", len(tt.api.updates), tt.updates)
+			}
+			if len(tt.api.creates) != tt.creates {
+				t.Errorf("updateRecord() made %d creates, want %d", len(tt.api.creates), tt.creates)
+			}
+			for _, u := range tt.api.updates {
+				if u.Content != "203.0.113.7" {
+					t.Errorf("updateRecord() updated %s to %q", u.ID, u.Content)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateRecordCached(t *testing.T) {
+	api := &fakeDNS{records: []cloudflare.DNSRecord{aRecord("1", "198.51.100.1")}}
+	opts := updateOptions{Cache: &lastKnownIP{store: newStateStore()}}
+	for i := 0; i < 2; i++ {
+		if _, err := updateRecord(context.Background(), api, "zone", "home.example.com", "A", "203.0.113.7", opts); err != nil {
+			t.Fatalf("updateRecord() error = %v", err)
+		}
+	}
+	if api.lists != 1 || len(api.updates) != 1 {
+		t.Errorf("updateRecord() listed %d times and updated %d times, want the second call to be cached", api.lists, len(api.updates))
+	}
+}
+
+func TestNetworkForProto(t *testing.T) {
+	tests := []struct {
+		network string
+		proto   RequestProto
+		want    string
+	}{
+		{"tcp", RequestProtoDefault, "tcp"},
+		{"tcp", RequestProtoIP4, "tcp4"},
+		{"tcp", RequestProtoIP6, "tcp6"},
+		{"tcp4", RequestProtoIP4, "tcp4"},
+		{"tcp4", RequestProtoIP6, "tcp6"},
+		{"tcp6", RequestProtoIP4, "tcp4"},
+		{"tcp6", RequestProtoIP6, "tcp6"},
+		{"udp", RequestProtoDefault, "udp"},
+		{"udp", RequestProtoIP4, "udp4"},
+		{"udp", RequestProtoIP6, "udp6"},
+		{"udp6", RequestProtoIP4, "udp4"},
+		{"udp6", RequestProtoIP6, "udp6"},
+		{"ip", RequestProtoIP4, "ip"},
+		{"ip", RequestProtoIP6, "ip"},
+		{"unix", RequestProtoIP4, "unix"},
+		{"unix", RequestProtoIP6, "unix"},
+	}
+	for _, tt := range tests {
+		if got := networkForProto(tt.network, tt.proto); got != tt.want {
+			t.Errorf("networkForProto(%q, %d) = %q, want %q", tt.network, tt.proto, got, tt.want)
+		}
+	}
+}
+
+func FuzzParseProviderOutput(f *testing.F) {
+	seeds := []string{
+		"",
+		"   \n",
+		"203.0.113.7",
+		"203.0.113.7\n",
+		"2001:db8::1",
+		"fe80::1%eth0",
+		"203.0.113.7/24",
+		"2001:db8::/64",
+		"203.0.113.7\n198.51.100.1",
+		"<html><body>203.0.113.7</body></html>",
+		"\uff12\uff10\uff13.\uff10.\uff11\uff11\uff13.\uff17",
+		"203.0.113.7\u200b",
+		strings.Repeat("1", 1<<16),
+	}
+	for _, seed := range seeds {
+		f.Add(seed, "text/plain")
+		f.Add(seed, "text/html; charset=utf-8")
+	}
+	f.Fuzz(func(t *testing.T, line, contentType string) {
+		ip, err := parseProviderOutput(line, contentType)
+		if err != nil {
+			return
+		}
+		if !ip.IsValid() {
+			t.Fatalf("parseProviderOutput(%q) = invalid address without an error", line)
+		}
+		if ip.Zone() != "" {
+			t.Fatalf("parseProviderOutput(%q) = %v with a zone", line, ip)
+		}
+		if parsed, err := netip.ParseAddr(ip.String()); err != nil || parsed != ip {
+			t.Fatalf("parseProviderOutput(%q) = %v, which doesn't round trip", line, ip)
+		}
+	})
+}
+
+func TestUpdateRecordDeclined(t *testing.T) {
+	api := &fakeDNS{records: []cloudflare.DNSRecord{aRecord("1", "198.51.100.1")}}
+	opts := updateOptions{
+		Cache:   &lastKnownIP{store: newStateStore()},
+		Confirm: &confirmer{in: bufio.NewReader(strings.NewReader("n\n")), out: io.Discard},
+	}
+	result, err := updateRecord(context.Background(), api, "zone", "home.example.com", "A", "203.0.113.7", opts)
+	if err != nil {
+		t.Fatalf("updateRecord() error = %v", err)
+	}
+	if !result.Declined || result.Changed || len(api.updates) != 0 {
+		t.Errorf("updateRecord() = %+v with %d updates, want the change declined", result, len(api.updates))
+	}
+	if opts.Cache.Matches("A", "home.example.com", "203.0.113.7", 0, nil) {
+		t.Error("the declined content was remembered")
+	}
+}
+
+func TestIsRecordLocked(t *testing.T) {
+	locked := &cloudflare.Error{StatusCode: 400, ErrorMessages: []string{"This record is managed by Email Routing"}}
+	// The client returns the typed errors as pointers.
+	managed := cloudflare.NewRequestError(locked)
+	invalid := cloudflare.NewRequestError(&cloudflare.Error{StatusCode: 400, ErrorMessages: []string{"Content for A record is invalid"}})
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain", stderrors.New("boom"), false},
+		{"locked", errors.Wrap(ErrRecordLocked, "A record home.example.com"), true},
+		{"request error", errors.Wrap(&cloudflare.RequestError{}, "update"), false},
+		{"authentication error", &cloudflare.AuthenticationError{}, false},
+		{"not found", &cloudflare.NotFoundError{}, false},
+		{"rate limited", &cloudflare.RatelimitError{}, false},
+		{"managed", errors.Wrap(&managed, "update"), true},
+		{"other request error", &invalid, false},
+		{"bare error", locked, true},
+		{"server error", &cloudflare.Error{StatusCode: 500, ErrorMessages: locked.ErrorMessages}, false},
+		{"bare not found", &cloudflare.Error{StatusCode: 404, ErrorMessages: locked.ErrorMessages}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRecordLocked(tt.err); got != tt.want {
+				t.Errorf("isRecordLocked(%v) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateRecordToggleProxy(t *testing.T) {
+	proxied := aRecord("1", "198.51.100.1")
+	proxied.Proxied = cloudflare.BoolPtr(true)
+	locked := proxied
+	locked.Locked = true
+
+	t.Run("toggled", func(t *testing.T) {
+		api := &fakeDNS{records: []cloudflare.DNSRecord{proxied}}
+		if _, err := updateRecord(context.Background(), api, "zone", "home.example.com", "A", "203.0.113.7", updateOptions{ToggleProxy: true, TTL: 300}); err != nil {
+			t.Fatalf("updateRecord() error = %v", err)
+		}
+		if len(api.updates) != 3 {
+			t.Fatalf("updateRecord() made %d updates, want 3", len(api.updates))
+		}
+		if off, change := api.updates[0], api.updates[1]; *off.Proxied || *change.Proxied || change.Content != "203.0.113.7" {
+			t.Errorf("updateRecord() didn't change the record while it was DNS only: %+v", api.updates)
+		}
+		if restore := api.updates[2]; !*restore.Proxied || restore.TTL != defaultTTL || restore.Content != "203.0.113.7" {
+			t.Errorf("updateRecord() restored the record with %+v", restore)
+		}
+	})
+	t.Run("locked", func(t *testing.T) {
+		api := &fakeDNS{records: []cloudflare.DNSRecord{locked}}
+		if _, err := updateRecord(context.Background(), api, "zone", "home.example.com", "A", "203.0.113.7", updateOptions{ToggleProxy: true}); !errors.Is(err, ErrRecordLocked) {
+			t.Fatalf("updateRecord() error = %v, want %v", err, ErrRecordLocked)
+		}
+		if len(api.updates) != 0 {
+			t.Errorf("updateRecord() made %d updates to the locked record", len(api.updates))
+		}
+	})
+}
+
+// roundTripFunc serves canned responses to the requests to the IP providers.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestGetCurrentIP(t *testing.T) {
+	tests := []struct {
+		name        string
+		endpoint    ProviderSpec
+		proto       RequestProto
+		status      int
+		contentType string
+		body        string
+		want        string
+		wantErr     error
+	}{
+		{"text", ProviderSpec{}, RequestProtoIP4, 200, "text/plain", "203.0.113.7", "203.0.113.7", nil},
+		{"whitespace", ProviderSpec{}, RequestProtoIP4, 200, "text/plain", "  203.0.113.7 \r\n", "203.0.113.7", nil},
+		{"multiline", ProviderSpec{}, RequestProtoIP4, 200, "text/plain", "203.0.113.7\n198.51.100.1\n", "203.0.113.7", nil},
+		{"cidr", ProviderSpec{}, RequestProtoIP4, 200, "text/plain", "203.0.113.7/32", "203.0.113.7", nil},
+		{"ipv6", ProviderSpec{}, RequestProtoIP6, 200, "text/plain", "2001:db8::1", "2001:db8::1", nil},
+		{"mapped", ProviderSpec{}, RequestProtoIP4, 200, "text/plain", "::ffff:203.0.113.7", "203.0.113.7", nil},
+		{"trace", ProviderSpec{URL: "https://1.1.1.1/cdn-cgi/trace"}, RequestProtoIP4, 200, "text/plain", "fl=1\nh=1.1.1.1\nip=203.0.113.7\nts=1\n", "203.0.113.7", nil},
+		{"json", ProviderSpec{}, RequestProtoIP4, 200, "application/json", `{"ip": "203.0.113.7"}`, "203.0.113.7", nil},
+		{"json path", ProviderSpec{Format: formatJSON, Path: "data.0.ip"}, RequestProtoIP4, 200, "text/plain", `{"data": [{"ip": "203.0.113.7"}]}`, "203.0.113.7", nil},
+		{"html", ProviderSpec{}, RequestProtoIP4, 200, "text/html", "<html><body>203.0.113.7</body></html>", "", nil},
+		{"empty", ProviderSpec{}, RequestProtoIP4, 200, "text/plain", "", "", ErrEmptyResponse},
+		{"empty json", ProviderSpec{Format: formatJSON}, RequestProtoIP4, 200, "application/json", "\n", "", ErrEmptyResponse},
+		{"family mismatch", ProviderSpec{}, RequestProtoIP6, 200, "text/plain", "203.0.113.7", "", nil},
+		{"server error", ProviderSpec{}, RequestProtoIP4, 503, "text/plain", "203.0.113.7", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var userAgent string
+			transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				userAgent = req.Header.Get("User-Agent")
+				return &http.Response{
+					StatusCode: tt.status,
+					Header:     http.Header{"Content-Type": {tt.contentType}},
+					Body:       io.NopCloser(strings.NewReader(tt.body)),
+					Request:    req,
+				}, nil
+			})
+			endpoint := tt.endpoint
+			if endpoint.URL == "" {
+				endpoint.URL = "https://ip.example.com/"
+			}
+			got, err := getCurrentIP(context.Background(), newIPProviderClient(tt.proto, bindOptions{}, transport, "cloudflare-ddns/test"), endpoint, tt.proto)
+			if userAgent != "cloudflare-ddns/test" {
+				t.Errorf("User-Agent = %q, want cloudflare-ddns/test", userAgent)
+			}
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("getCurrentIP() = %v, want an error", got)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("getCurrentIP() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getCurrentIP() error = %v", err)
+			}
+			if got != netip.MustParseAddr(tt.want) {
+				t.Errorf("getCurrentIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPIPSourceTransport(t *testing.T) {
+	var hosts []string
+	source := httpIPSource{
+		Endpoints: []ProviderSpec{{URL: "https://ip.example.com/"}},
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			hosts = append(hosts, req.URL.Host)
+			return &http.Response{
+				StatusCode: 200,
+				Header:     http.Header{"Content-Type": {"text/plain"}},
+				Body:       io.NopCloser(strings.NewReader("203.0.113.7\n")),
+				Request:    req,
+			}, nil
+		}),
+	}
+	got, err := source.GetIP(context.Background(), RequestProtoIP4)
+	if err != nil {
+		t.Fatalf("GetIP() error = %v", err)
+	}
+	if got != netip.MustParseAddr("203.0.113.7") || len(hosts) != 1 {
+		t.Errorf("GetIP() = %v after %d requests, want 203.0.113.7 after 1", got, len(hosts))
+	}
+}
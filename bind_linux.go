@@ -0,0 +1,22 @@
+package main
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// bindToDevice returns a dialer control function that binds the socket to the
+// network interface with SO_BINDTODEVICE.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		})
+		if err != nil {
+			return err
+		}
+		return errors.Wrapf(sockErr, "could not bind to interface %s", iface)
+	}
+}
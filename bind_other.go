@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// bindToDevice is only supported on Linux.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return errors.Errorf("binding to interface %s is not supported on this platform", iface)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// circuitBreaker skips the IP providers that failed Threshold times in a row
+// for Cooldown, after which a single request probes the provider again. The
+// families of a provider are tracked apart, an IPv6 outage doesn't stop the
+// IPv4 requests.
+type circuitBreaker struct {
+	mu sync.Mutex
+	// Threshold of consecutive failures that opens the circuit, zero disables it.
+	Threshold int
+	Cooldown  time.Duration
+	failures  map[circuitKey]int
+	openUntil map[circuitKey]time.Time
+}
+
+// circuitKey is the provider requested over a family.
+type circuitKey struct {
+	Endpoint string
+	Proto    RequestProto
+}
+
+var providerBreaker = newCircuitBreaker(3, 5*time.Minute)
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		failures:  map[circuitKey]int{},
+		openUntil: map[circuitKey]time.Time{},
+	}
+}
+
+// allow reports whether the provider may be tried over the family at now.
+func (b *circuitBreaker) allow(endpoint string, proto RequestProto, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !now.Before(b.openUntil[circuitKey{endpoint, proto}])
+}
+
+func (b *circuitBreaker) success(endpoint string, proto RequestProto) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := circuitKey{endpoint, proto}
+	if !b.openUntil[key].IsZero() {
+		logrus.WithFields(logrus.Fields{
+			"event":    "ip_provider_circuit_closed",
+			"endpoint": endpoint,
+			"proto":    proto,
+		}).Info("ip provider recovered, closing the circuit")
+	}
+	delete(b.failures, key)
+	delete(b.openUntil, key)
+}
+
+func (b *circuitBreaker) failure(endpoint string, proto RequestProto, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := circuitKey{endpoint, proto}
+	b.failures[key]++
+	if b.Threshold <= 0 || b.failures[key] < b.Threshold {
+		return
+	}
+	b.openUntil[key] = now.Add(b.Cooldown)
+	logrus.WithFields(logrus.Fields{
+		"event":    "ip_provider_circuit_opened",
+		"endpoint": endpoint,
+		"proto":    proto,
+		"failures": b.failures[key],
+		"cooldown": b.Cooldown,
+	}).Warn("ip provider keeps failing, opening the circuit")
+}
+
+// open returns the providers with a circuit that is open at now, sorted. The
+// circuits past their cooldown are half open, they're probed again.
+func (b *circuitBreaker) open(now time.Time) []circuitKey {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]circuitKey, 0, len(b.openUntil))
+	for key, until := range b.openUntil {
+		if now.Before(until) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Endpoint != keys[j].Endpoint {
+			return keys[i].Endpoint < keys[j].Endpoint
+		}
+		return keys[i].Proto < keys[j].Proto
+	})
+	return keys
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	const endpoint = "https://ip.example.com"
+	b := newCircuitBreaker(2, time.Minute)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		b.failure(endpoint, RequestProtoIP6, now)
+	}
+
+	if b.allow(endpoint, RequestProtoIP6, now) {
+		t.Error("the circuit of the failing family is closed")
+	}
+	if !b.allow(endpoint, RequestProtoIP4, now) {
+		t.Error("the failures of IPv6 opened the circuit of IPv4")
+	}
+	if open := b.open(now); len(open) != 1 || open[0] != (circuitKey{endpoint, RequestProtoIP6}) {
+		t.Errorf("open() = %v, want the IPv6 circuit", open)
+	}
+
+	later := now.Add(time.Minute)
+	if !b.allow(endpoint, RequestProtoIP6, later) {
+		t.Error("the circuit is still open after the cooldown")
+	}
+	if open := b.open(later); len(open) != 0 {
+		t.Errorf("open() = %v after the cooldown, want none", open)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// Check will verify the credentials, the zone and that the configured records
+// exist without changing anything.
+func Check(c *cli.Context) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	cfg, api, err := setup(c)
+	if err != nil {
+		return err
+	}
+
+	var problems int
+	fail := func(err error, msg string) {
+		problems++
+		logrus.WithError(err).WithField("event", "check_problem").Error(msg)
+	}
+
+	if cfg.Token != "" {
+		token, err := api.VerifyAPIToken(ctx)
+		switch {
+		case err != nil:
+			fail(err, "could not verify the API token")
+		case token.Status != "active":
+			fail(fmt.Errorf("token status is %q", token.Status), "the API token is not active")
+		default:
+			logrus.WithFields(logrus.Fields{
+				"event":      "check_token_valid",
+				"expires_on": token.ExpiresOn,
+			}).Info("API token is valid")
+		}
+	} else {
+		if _, err := api.UserDetails(ctx); err != nil {
+			fail(err, "could not verify the API key")
+		} else {
+			logrus.WithField("event", "check_key_valid").Info("API key is valid")
+		}
+	}
+
+	resolver := newZoneResolver(api)
+	resolver.wait = c.Duration("wait-for-zone")
+	for _, zone := range cfg.zoneSpecs() {
+		zoneID := zone.ZoneID
+		if zoneID != "" {
+			if _, err := api.ZoneDetails(ctx, zoneID); err != nil {
+				fail(err, "could not find the zone by ID")
+				continue
+			}
+		} else if zoneID, err = resolver.Resolve(ctx, zone.Zone); err != nil {
+			fail(err, "could not find the zone by name")
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"event":   "check_zone_found",
+			"zone":    zone.name(),
+			"zone_id": zoneID,
+		}).Info("zone found")
+		for _, r := range zone.Records {
+			log := logrus.WithFields(logrus.Fields{
+				"name": r.Name,
+				"type": r.Type,
+			})
+			// The records with an ID are fetched the way they're updated, with
+			// only the permission to read the record.
+			if r.ID != "" {
+				record, err := getRecordByID(ctx, api, zoneID, r.Name, r.Type, r.ID)
+				if err != nil {
+					fail(err, "could not get the dns record by its id")
+					continue
+				}
+				log.WithFields(logrus.Fields{
+					"event":     "check_record_found",
+					"record_id": record.ID,
+					"content":   record.Content,
+				}).Info("record found")
+				continue
+			}
+
+			// The auto records can be either A or AAAA records.
+			recordType := r.Type
+			if recordType == "auto" {
+				recordType = ""
+			}
+			records, _, err := api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{
+				Name: r.Name,
+				Type: recordType,
+			})
+			if r.Type == "auto" {
+				records = slices.DeleteFunc(records, func(record cloudflare.DNSRecord) bool {
+					return record.Type != "A" && record.Type != "AAAA"
+				})
+			}
+			switch {
+			case err != nil:
+				fail(err, "could not list the dns records")
+			case len(records) == 0 && cfg.CreateIfMissing:
+				log.WithField("event", "check_record_missing").Info("record does not exist and will be created")
+			case len(records) == 0:
+				fail(errors.Wrapf(ErrRecordNotFound, "no %s record %s", r.Type, r.Name), "record not found")
+			case len(records) > 1 && !cfg.UpdateAll && len(r.Sources) == 0:
+				fail(errors.Wrapf(ErrMultipleRecords, "found %d %s records %s", len(records), r.Type, r.Name), "multiple records found, use --update-all")
+			default:
+				log.WithFields(logrus.Fields{
+					"event":   "check_record_found",
+					"content": records[0].Content,
+				}).Info("record found")
+			}
+		}
+	}
+
+	if problems > 0 {
+		return cli.Exit(fmt.Sprintf("check failed with %d problem(s)", problems), 1)
+	}
+	logrus.WithField("event", "check_passed").Info("check passed")
+	return nil
+}
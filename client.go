@@ -0,0 +1,40 @@
+package main
+
+import (
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/pkg/errors"
+)
+
+// authConfig holds the Cloudflare credentials, either a scoped API token or
+// the global API key together with the account email.
+type authConfig struct {
+	Token string
+	Key   string
+	Email string
+}
+
+func (cfg authConfig) validate() error {
+	hasKey := cfg.Key != "" || cfg.Email != ""
+	switch {
+	case cfg.Token != "" && hasKey:
+		return errors.New("either --key and --email or --token must be defined, not both")
+	case cfg.Token != "":
+		return nil
+	case cfg.Key != "" && cfg.Email != "":
+		return nil
+	case hasKey:
+		return errors.New("both --key and --email must be defined")
+	default:
+		return errors.New("either --key and --email or --token must be defined")
+	}
+}
+
+func newCloudflareClient(cfg authConfig, opts ...cloudflare.Option) (*cloudflare.API, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Token != "" {
+		return cloudflare.NewWithAPIToken(cfg.Token, opts...)
+	}
+	return cloudflare.New(cfg.Key, cfg.Email, opts...)
+}
@@ -0,0 +1,686 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultIPURLs are the providers used when none are configured. The address
+// literal of the trace endpoint is only reachable over IPv4, the IPv6 address
+// has its own providers.
+var (
+	defaultIPURLs = []string{
+		"https://1.1.1.1/cdn-cgi/trace",
+		"https://api.ipify.org",
+		"https://icanhazip.com",
+	}
+	defaultIP6URLs = []string{
+		"https://[2606:4700:4700::1111]/cdn-cgi/trace",
+		"https://api6.ipify.org",
+		"https://ipv6.icanhazip.com",
+	}
+)
+
+// Config is the configuration of the updater, loaded from a YAML file or
+// assembled from the command line flags.
+type Config struct {
+	Token string `yaml:"token"`
+	Key   string `yaml:"key"`
+	Email string `yaml:"email"`
+	// TokenFile, KeyFile and EmailFile are read into Token, Key and Email,
+	// i.e. from the mounted Docker or Kubernetes secrets.
+	TokenFile string `yaml:"token_file"`
+	KeyFile   string `yaml:"key_file"`
+	EmailFile string `yaml:"email_file"`
+
+	Zone string `yaml:"zone"`
+	// ZoneID skips the lookup of the zone by name, it takes precedence over Zone.
+	ZoneID  string       `yaml:"zone_id"`
+	Records []RecordSpec `yaml:"records"`
+	// Zones are more zones with their own records, updated on the same
+	// schedule as the records of Zone.
+	Zones []ZoneSpec `yaml:"zones"`
+
+	// Source selects where the IP address is detected: "http", "interface:<name>",
+	// "dns", "stun[:host:port]" or "upnp".
+	Source  string   `yaml:"source"`
+	IPURLs  []string `yaml:"ip_urls"`
+	IP4URLs []string `yaml:"ip4_urls"`
+	IP6URLs []string `yaml:"ip6_urls"`
+	// Providers are the IP providers with the format of their responses, the
+	// ones of a family take precedence over the URLs.
+	Providers []ProviderSpec `yaml:"providers"`
+	// StrictIP requires two different endpoints to agree on the address.
+	StrictIP bool `yaml:"strict_ip"`
+	// BindInterface and BindAddress select the local interface or address the
+	// requests to the endpoints are made from.
+	BindInterface string `yaml:"bind_interface"`
+	BindAddress   string `yaml:"bind_address"`
+	// IPv6Suffix replaces the host part of the detected IPv6 address after the
+	// first IPv6PrefixLen bits, i.e. "::1234".
+	IPv6Suffix    string `yaml:"ipv6_suffix"`
+	IPv6PrefixLen int    `yaml:"ipv6_prefix_len"`
+	// AllowedCIDRs and DeniedCIDRs restrict the detected addresses the records
+	// may be pointed at, i.e. to the prefixes of the ISP.
+	AllowedCIDRs []string `yaml:"allowed_cidrs"`
+	DeniedCIDRs  []string `yaml:"denied_cidrs"`
+	// FallbackIPs are the addresses, one per family, the records are pointed
+	// at when the address of the family can't be detected at all.
+	FallbackIPs []string `yaml:"fallback_ips"`
+	// IPCacheFile shares the detected addresses with other tools, they're read
+	// from the file until they're older than IPCacheTTL and then detected and
+	// written back unless IPCacheReadOnly is set.
+	IPCacheFile     string        `yaml:"ip_cache_file"`
+	IPCacheTTL      time.Duration `yaml:"ip_cache_ttl"`
+	IPCacheReadOnly bool          `yaml:"ip_cache_readonly"`
+
+	Interval time.Duration `yaml:"interval"`
+	// TTL of the records, 1 means automatic. Unset keeps the current TTL.
+	TTL int `yaml:"ttl"`
+	// Proxied forces the proxied state of the records, unset keeps the current one.
+	Proxied         *bool `yaml:"proxied"`
+	CreateIfMissing bool  `yaml:"create_if_missing"`
+	// UpdateAll updates every matching record when there are more than one.
+	UpdateAll bool   `yaml:"update_all"`
+	StateFile string `yaml:"state_file"`
+	// Comment is set on the created and updated records, it's a text/template
+	// with the .Time field, i.e. "managed by cloudflare-ddns at {{.Time}}".
+	// Unset keeps the current comment.
+	Comment string `yaml:"comment"`
+	// Tags replace the tags of the created and updated records, unset keeps
+	// the current tags.
+	Tags []string `yaml:"tags"`
+	// TagSelector also updates the A and AAAA records carrying the tag, i.e.
+	// "ddns:home", in all of the zones the credentials can access.
+	TagSelector string `yaml:"tag_selector"`
+	// UserAgent of the requests to the IP providers, cloudflare-ddns/<version>
+	// by default.
+	UserAgent string `yaml:"user_agent"`
+	// Writers are the additional DNS providers the records are written to,
+	// i.e. to keep a backup of the records.
+	Writers []WriterSpec `yaml:"writers"`
+}
+
+// WriterSpec is an additional DNS provider the records are written to. The
+// zones are looked up there by the same names.
+type WriterSpec struct {
+	// Type of the provider, only "cloudflare" (another account) is supported.
+	Type      string `yaml:"type"`
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"token_file"`
+}
+
+// ZoneSpec is a zone and the records that are kept up to date in it.
+type ZoneSpec struct {
+	Zone    string       `yaml:"zone"`
+	ZoneID  string       `yaml:"zone_id"`
+	Records []RecordSpec `yaml:"records"`
+	// TTL and Proxied are the defaults of the records of the zone, they
+	// override the ones of the config and are overridden by the records.
+	TTL     int   `yaml:"ttl"`
+	Proxied *bool `yaml:"proxied"`
+}
+
+// options returns opts with the record defaults of the zone.
+func (z ZoneSpec) options(opts updateOptions) updateOptions {
+	if z.TTL != 0 {
+		opts.TTL = z.TTL
+	}
+	if z.Proxied != nil {
+		opts.Proxied = z.Proxied
+	}
+	return opts
+}
+
+// name returns the zone name, or the ID when the name isn't set.
+func (z ZoneSpec) name() string {
+	if z.Zone != "" {
+		return z.Zone
+	}
+	return z.ZoneID
+}
+
+// ProviderSpec is an IP provider and how its response is parsed.
+type ProviderSpec struct {
+	URL string `yaml:"url"`
+	// Proto restricts the provider to a family, "4" or "6". Unset uses it for
+	// both.
+	Proto string `yaml:"proto"`
+	// Format of the response: "text" (the address on the first line), "trace"
+	// (the key=value lines of the Cloudflare trace) or "json". Unset is trace
+	// for the Cloudflare trace endpoints, json for the json responses and text
+	// otherwise.
+	Format string `yaml:"format"`
+	// Path of the address in the json response, the keys and the array
+	// indexes separated by dots, i.e. "data.ip". Unset is "ip", as returned by
+	// i.e. https://api.ipify.org?format=json.
+	Path string `yaml:"path"`
+}
+
+// The formats of the responses of the IP providers.
+const (
+	formatText  = "text"
+	formatTrace = "trace"
+	formatJSON  = "json"
+)
+
+// defaultJSONPath is the field of the address in the json responses.
+const defaultJSONPath = "ip"
+
+// validate checks the spec of the i-th provider.
+func (p ProviderSpec) validate(i int) error {
+	if !strings.HasPrefix(p.URL, "http://") && !strings.HasPrefix(p.URL, "https://") {
+		return errors.Errorf("invalid providers[%d].url %q, must be an http or https url", i, p.URL)
+	}
+	if p.Proto != "" && p.Proto != "4" && p.Proto != "6" {
+		return errors.Errorf("invalid providers[%d].proto %q, must be 4 or 6", i, p.Proto)
+	}
+	switch p.Format {
+	case "", formatText, formatTrace, formatJSON:
+	default:
+		return errors.Errorf("invalid providers[%d].format %q, must be text, trace or json", i, p.Format)
+	}
+	return nil
+}
+
+// RecordSpec identifies a DNS record that should be kept up to date.
+type RecordSpec struct {
+	Name string `yaml:"name"`
+	// ID of the record skips listing the records of the name and type, i.e.
+	// for the tokens that can't list them.
+	ID string `yaml:"id"`
+	// Type of the record, "auto" picks A or AAAA by the detected address.
+	Type string `yaml:"type"`
+	// Proxied overrides the proxied setting of the config for this record.
+	Proxied *bool `yaml:"proxied"`
+	// TTL overrides the TTL of the config for this record.
+	TTL int `yaml:"ttl"`
+	// Source overrides the IP source of the config for this record, i.e. to
+	// track the address of another WAN interface.
+	Source string `yaml:"source"`
+	// Sources keep one A or AAAA record of the name for the address of each
+	// of the IP sources (round-robin), i.e. of two WAN links. The records of
+	// the name that point elsewhere are deleted.
+	Sources []string `yaml:"sources"`
+	// Content is a text/template of the record content with the .IP4 and .IP6
+	// fields, i.e. "v=spf1 ip4:{{.IP4}} -all". It's required for the types
+	// other than A and AAAA which default to the current address.
+	Content string `yaml:"content"`
+	// SRV are the fields of the SRV records, which have no content.
+	SRV *SRVSpec `yaml:"srv"`
+}
+
+// options returns opts with the settings of the record. The settings of the
+// record take precedence over the ones of the zone, which take precedence
+// over the ones of the config. The unset ones keep the settings of the
+// existing record or the Cloudflare defaults of the created one.
+func (r RecordSpec) options(opts updateOptions) updateOptions {
+	if r.TTL != 0 {
+		opts.TTL = r.TTL
+	}
+	if r.Proxied != nil {
+		opts.Proxied = r.Proxied
+	}
+	return opts
+}
+
+// SRVSpec is the data of an SRV record, i.e. of _minecraft._tcp.example.com
+// with the target home.example.com that follows the current address.
+type SRVSpec struct {
+	Priority uint16 `yaml:"priority"`
+	Weight   uint16 `yaml:"weight"`
+	Port     uint16 `yaml:"port"`
+	Target   string `yaml:"target"`
+}
+
+// content returns the content of the record as the API shows it.
+func (s SRVSpec) content() string {
+	return fmt.Sprintf("%d %d %s", s.Weight, s.Port, s.Target)
+}
+
+// data returns the data of the record the API updates it with.
+func (s SRVSpec) data() map[string]any {
+	return map[string]any{
+		"priority": s.Priority,
+		"weight":   s.Weight,
+		"port":     s.Port,
+		"target":   s.Target,
+	}
+}
+
+// needsIP reports whether the content of the record depends on the current
+// address of the given family. The "auto" records need RequestProtoDefault,
+// the address of whatever family the source returns.
+func (r RecordSpec) needsIP(proto RequestProto) bool {
+	if r.Type == "auto" {
+		return proto == RequestProtoDefault
+	}
+	if r.Content != "" {
+		field := ".IP4"
+		if proto == RequestProtoIP6 {
+			field = ".IP6"
+		}
+		return strings.Contains(r.Content, field)
+	}
+	recordProto, err := protoForRecordType(r.Type)
+	return err == nil && proto != RequestProtoDefault && recordProto == proto
+}
+
+// render returns the content of the record for the current addresses.
+func (r RecordSpec) render(ip4, ip6 netip.Addr) (string, error) {
+	if r.SRV != nil {
+		return r.SRV.content(), nil
+	}
+	if r.Content == "" {
+		switch r.Type {
+		case "A":
+			return ip4.String(), nil
+		case "AAAA":
+			return ip6.String(), nil
+		}
+		return "", errors.Errorf("missing content for %s record %s", r.Type, r.Name)
+	}
+
+	t, err := template.New(r.Name).Option("missingkey=error").Parse(r.Content)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid content template for %s", r.Name)
+	}
+	var b strings.Builder
+	err = t.Execute(&b, struct{ IP4, IP6 netip.Addr }{ip4, ip6})
+	if err != nil {
+		return "", errors.Wrapf(err, "could not render the content for %s", r.Name)
+	}
+	return b.String(), nil
+}
+
+// renderComment returns the record comment for the template at now.
+func renderComment(comment string, now time.Time) (string, error) {
+	t, err := template.New("comment").Option("missingkey=error").Parse(comment)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid comment template")
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, struct{ Time string }{now.UTC().Format(time.RFC3339)}); err != nil {
+		return "", errors.Wrap(err, "could not render the comment")
+	}
+	return b.String(), nil
+}
+
+// LoadConfig reads the YAML config file at path. Environment variables in the
+// string values (i.e. ${CF_API_TOKEN}) are expanded. The config isn't
+// validated, the flags may still complete it.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read the config file")
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, errors.Wrap(err, "could not parse the config file")
+	}
+	expandEnv(&root)
+
+	cfg := &Config{}
+	if err := root.Decode(cfg); err != nil {
+		return nil, errors.Wrap(err, "could not parse the config file")
+	}
+	cfg.applyDefaults()
+	return cfg, nil
+}
+
+func expandEnv(n *yaml.Node) {
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!str" {
+		n.Value = os.ExpandEnv(n.Value)
+	}
+	for _, c := range n.Content {
+		expandEnv(c)
+	}
+}
+
+func (cfg *Config) applyDefaults() {
+	if len(cfg.IPURLs) == 0 {
+		cfg.IPURLs = defaultIPURLs
+		if len(cfg.IP6URLs) == 0 {
+			cfg.IP6URLs = defaultIP6URLs
+		}
+	}
+	if cfg.IPv6PrefixLen == 0 {
+		cfg.IPv6PrefixLen = 64
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = "cloudflare-ddns/" + readBuildInfo().Version
+	}
+	if cfg.IPCacheTTL == 0 {
+		cfg.IPCacheTTL = 5 * time.Minute
+	}
+}
+
+// readSecrets reads the credentials from their files, the trimmed contents of
+// a file replace the value set directly.
+func (cfg *Config) readSecrets() error {
+	for _, s := range []struct {
+		path string
+		dst  *string
+	}{
+		{cfg.TokenFile, &cfg.Token},
+		{cfg.KeyFile, &cfg.Key},
+		{cfg.EmailFile, &cfg.Email},
+	} {
+		if s.path == "" {
+			continue
+		}
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			return errors.Wrap(err, "could not read the credentials file")
+		}
+		*s.dst = strings.TrimSpace(string(data))
+	}
+	for i, w := range cfg.Writers {
+		if w.TokenFile == "" {
+			continue
+		}
+		data, err := os.ReadFile(w.TokenFile)
+		if err != nil {
+			return errors.Wrapf(err, "could not read the token file of writers[%d]", i)
+		}
+		cfg.Writers[i].Token = strings.TrimSpace(string(data))
+	}
+	return nil
+}
+
+// zoneSpecs returns all of the zones, the one of the top level Zone first.
+func (cfg *Config) zoneSpecs() []ZoneSpec {
+	if cfg.Zone == "" && cfg.ZoneID == "" && len(cfg.Records) == 0 {
+		return cfg.Zones
+	}
+	top := ZoneSpec{Zone: cfg.Zone, ZoneID: cfg.ZoneID, Records: cfg.Records}
+	return append([]ZoneSpec{top}, cfg.Zones...)
+}
+
+// records returns the records of all of the zones.
+func (cfg *Config) records() []RecordSpec {
+	var records []RecordSpec
+	for _, z := range cfg.zoneSpecs() {
+		records = append(records, z.Records...)
+	}
+	return records
+}
+
+// Validate returns an error listing all of the missing required keys.
+func (cfg *Config) Validate() error {
+	var missing []string
+	if cfg.TagSelector == "" && len(cfg.Zones) == 0 || cfg.Zone != "" || cfg.ZoneID != "" || len(cfg.Records) > 0 {
+		missing = append(missing, missingZoneKeys("", ZoneSpec{Zone: cfg.Zone, ZoneID: cfg.ZoneID, Records: cfg.Records})...)
+	}
+	for i, z := range cfg.Zones {
+		missing = append(missing, missingZoneKeys(fmt.Sprintf("zones[%d].", i), z)...)
+		if z.TTL != 0 {
+			if err := validateTTL(z.TTL); err != nil {
+				return errors.Wrapf(err, "zones[%d]", i)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return errors.Errorf("missing required config keys: %s", strings.Join(missing, ", "))
+	}
+
+	if _, err := newIPSource(cfg.Source, httpIPSource{}); err != nil {
+		return err
+	}
+	for i, p := range cfg.Providers {
+		if err := p.validate(i); err != nil {
+			return err
+		}
+	}
+	if cfg.IPv6Suffix != "" {
+		suffix, err := netip.ParseAddr(cfg.IPv6Suffix)
+		if err != nil || !suffix.Is6() {
+			return errors.Errorf("invalid ipv6_suffix %q", cfg.IPv6Suffix)
+		}
+		if cfg.IPv6PrefixLen < 0 || cfg.IPv6PrefixLen > 128 {
+			return errors.Errorf("invalid ipv6_prefix_len %d", cfg.IPv6PrefixLen)
+		}
+	}
+	if _, err := renderComment(cfg.Comment, time.Now()); err != nil {
+		return err
+	}
+	if _, err := parsePrefixes(cfg.AllowedCIDRs); err != nil {
+		return errors.Wrap(err, "invalid allowed_cidrs")
+	}
+	if _, err := parsePrefixes(cfg.DeniedCIDRs); err != nil {
+		return errors.Wrap(err, "invalid denied_cidrs")
+	}
+	if _, _, err := cfg.fallbackIPs(); err != nil {
+		return err
+	}
+	if cfg.BindAddress != "" {
+		if _, err := netip.ParseAddr(cfg.BindAddress); err != nil {
+			return errors.Wrap(err, "invalid bind_address")
+		}
+	}
+	if cfg.TTL != 0 {
+		if err := validateTTL(cfg.TTL); err != nil {
+			return err
+		}
+	}
+	for _, r := range cfg.records() {
+		if r.Type == "auto" && r.Content != "" {
+			return errors.Errorf("auto record %s can't have a content", r.Name)
+		}
+		if r.SRV != nil {
+			if r.Type != "SRV" || r.Content != "" {
+				return errors.Errorf("only the SRV records without a content can have the srv fields, %s record %s", r.Type, r.Name)
+			}
+			if r.SRV.Target == "" || r.SRV.Port == 0 {
+				return errors.Errorf("SRV record %s needs the srv target and port", r.Name)
+			}
+		}
+		if r.Type != "A" && r.Type != "AAAA" && r.Type != "auto" && r.Content == "" && r.SRV == nil {
+			return errors.Errorf("%s record %s needs a content", r.Type, r.Name)
+		}
+		if r.Content != "" {
+			if _, err := template.New(r.Name).Parse(r.Content); err != nil {
+				return errors.Wrapf(err, "invalid content template for %s", r.Name)
+			}
+		}
+		if r.TTL != 0 {
+			if err := validateTTL(r.TTL); err != nil {
+				return errors.Wrap(err, r.Name)
+			}
+		}
+		if r.Source != "" {
+			if _, err := newIPSource(r.Source, httpIPSource{}); err != nil {
+				return errors.Wrap(err, r.Name)
+			}
+			// Only the content templates of the other types use the address.
+			if _, err := protoForRecordType(r.Type); err != nil && r.Content == "" {
+				return errors.Wrapf(err, "%s record %s can't have a source without a content", r.Type, r.Name)
+			}
+		}
+		if r.ID != "" && r.Type == "auto" {
+			return errors.Errorf("auto record %s can't have an id, the id is of a record of a single type", r.Name)
+		}
+		if len(r.Sources) > 0 {
+			if proto, err := protoForRecordType(r.Type); err != nil || proto == RequestProtoDefault || r.Source != "" || r.Content != "" || r.ID != "" {
+				return errors.Errorf("only the A and AAAA records without a source and a content can have sources, %s record %s", r.Type, r.Name)
+			}
+			for _, source := range r.Sources {
+				if _, err := newIPSource(source, httpIPSource{}); err != nil {
+					return errors.Wrap(err, r.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// missingZoneKeys returns the missing required keys of the zone, prefixed.
+func missingZoneKeys(prefix string, z ZoneSpec) []string {
+	var missing []string
+	if z.Zone == "" && z.ZoneID == "" {
+		missing = append(missing, prefix+"zone or "+prefix+"zone_id")
+	}
+	if len(z.Records) == 0 {
+		missing = append(missing, prefix+"records")
+	}
+	for i, r := range z.Records {
+		if r.Name == "" {
+			missing = append(missing, fmt.Sprintf("%srecords[%d].name", prefix, i))
+		}
+		if r.Type == "" {
+			missing = append(missing, fmt.Sprintf("%srecords[%d].type", prefix, i))
+		}
+	}
+	return missing
+}
+
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func (cfg *Config) bind() bindOptions {
+	addr, _ := netip.ParseAddr(cfg.BindAddress)
+	return bindOptions{
+		Interface: cfg.BindInterface,
+		Address:   addr,
+	}
+}
+
+// fallbackIPs returns the fallback addresses of both of the families.
+func (cfg *Config) fallbackIPs() (netip.Addr, netip.Addr, error) {
+	var ip4, ip6 netip.Addr
+	for _, s := range cfg.FallbackIPs {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return ip4, ip6, errors.Wrapf(err, "invalid fallback_ips")
+		}
+		ip = ip.Unmap()
+		dst := &ip4
+		if ip.Is6() {
+			dst = &ip6
+		}
+		if dst.IsValid() {
+			return ip4, ip6, errors.Errorf("invalid fallback_ips, more than one address of the family of %v", ip)
+		}
+		*dst = ip
+	}
+	return ip4, ip6, nil
+}
+
+// ipProvider returns the provider of the current address of the given family
+// for the IP source selector.
+func (cfg *Config) ipProvider(selector string, proto RequestProto) (ipProvider, error) {
+	source, err := newIPSource(selector, httpIPSource{
+		Endpoints: cfg.endpoints(proto),
+		Strict:    cfg.StrictIP,
+		Bind:      cfg.bind(),
+		UserAgent: cfg.UserAgent,
+	})
+	if err != nil {
+		return ipProvider{}, err
+	}
+	if cfg.IPCacheFile != "" {
+		source = cachedFileSource{
+			Path:     cfg.IPCacheFile,
+			Selector: selector,
+			TTL:      cfg.IPCacheTTL,
+			ReadOnly: cfg.IPCacheReadOnly,
+			Live:     source,
+		}
+	}
+	if len(cfg.FallbackIPs) > 0 {
+		// The fallback addresses were validated with the config.
+		ip4, ip6, _ := cfg.fallbackIPs()
+		source = fallbackIPSource{Live: source, IP4: ip4, IP6: ip6}
+	}
+	source = memoIPSource{Key: selector, Live: source}
+
+	p := ipProvider{Source: source}
+	if proto != RequestProtoIP4 && cfg.IPv6Suffix != "" {
+		p.IPv6Suffix = netip.MustParseAddr(cfg.IPv6Suffix)
+		p.IPv6PrefixLen = cfg.IPv6PrefixLen
+	}
+	return p, nil
+}
+
+// sourceProviders returns the providers of every IP source used by the records.
+func (cfg *Config) sourceProviders() (map[string]sourceProviders, error) {
+	providers := map[string]sourceProviders{}
+	records := cfg.records()
+	if cfg.TagSelector != "" {
+		// The discovered records use the default source.
+		records = append(records, RecordSpec{})
+	}
+	var selectors []string
+	for _, r := range records {
+		switch {
+		case len(r.Sources) > 0:
+			selectors = append(selectors, r.Sources...)
+		case r.Source != "":
+			selectors = append(selectors, r.Source)
+		default:
+			selectors = append(selectors, cfg.Source)
+		}
+	}
+	for _, selector := range selectors {
+		if _, ok := providers[selector]; ok {
+			continue
+		}
+
+		var p sourceProviders
+		var err error
+		if p.IP4, err = cfg.ipProvider(selector, RequestProtoIP4); err != nil {
+			return nil, err
+		}
+		if p.IP6, err = cfg.ipProvider(selector, RequestProtoIP6); err != nil {
+			return nil, err
+		}
+		if p.Any, err = cfg.ipProvider(selector, RequestProtoDefault); err != nil {
+			return nil, err
+		}
+		providers[selector] = p
+	}
+	return providers, nil
+}
+
+// endpoints returns the ip address service endpoints for the given protocol.
+// The providers of the family take precedence over the URLs.
+func (cfg *Config) endpoints(proto RequestProto) []ProviderSpec {
+	var providers []ProviderSpec
+	for _, p := range cfg.Providers {
+		if p.Proto == "" || proto == RequestProtoDefault || p.Proto == "4" && proto == RequestProtoIP4 || p.Proto == "6" && proto == RequestProtoIP6 {
+			providers = append(providers, p)
+		}
+	}
+	if len(providers) > 0 {
+		return providers
+	}
+
+	urls := cfg.IPURLs
+	switch {
+	case proto == RequestProtoIP4 && len(cfg.IP4URLs) > 0:
+		urls = cfg.IP4URLs
+	case proto == RequestProtoIP6 && len(cfg.IP6URLs) > 0:
+		urls = cfg.IP6URLs
+	}
+	for _, url := range urls {
+		providers = append(providers, ProviderSpec{URL: url})
+	}
+	return providers
+}
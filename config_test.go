@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+)
+
+func TestRecordOptionsPrecedence(t *testing.T) {
+	yes, no := cloudflare.BoolPtr(true), cloudflare.BoolPtr(false)
+	tests := []struct {
+		name        string
+		global      updateOptions
+		zone        ZoneSpec
+		record      RecordSpec
+		wantTTL     int
+		wantProxied *bool
+	}{
+		{
+			name: "cloudflare default",
+		},
+		{
+			name:        "global",
+			global:      updateOptions{TTL: 300, Proxied: yes},
+			wantTTL:     300,
+			wantProxied: yes,
+		},
+		{
+			name:        "zone over global",
+			global:      updateOptions{TTL: 300, Proxied: yes},
+			zone:        ZoneSpec{TTL: 600, Proxied: no},
+			wantTTL:     600,
+			wantProxied: no,
+		},
+		{
+			name:        "record over zone",
+			global:      updateOptions{TTL: 300, Proxied: yes},
+			zone:        ZoneSpec{TTL: 600, Proxied: no},
+			record:      RecordSpec{TTL: 120, Proxied: yes},
+			wantTTL:     120,
+			wantProxied: yes,
+		},
+		{
+			name:        "record over global",
+			global:      updateOptions{TTL: 300, Proxied: yes},
+			record:      RecordSpec{Proxied: no},
+			wantTTL:     300,
+			wantProxied: no,
+		},
+		{
+			name:        "zone only",
+			zone:        ZoneSpec{TTL: 600},
+			wantTTL:     600,
+			wantProxied: nil,
+		},
+		{
+			name:        "separate settings",
+			global:      updateOptions{Proxied: yes},
+			zone:        ZoneSpec{TTL: 600},
+			record:      RecordSpec{Proxied: no},
+			wantTTL:     600,
+			wantProxied: no,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.record.options(tt.zone.options(tt.global))
+			if opts.TTL != tt.wantTTL {
+				t.Errorf("TTL = %d, want %d", opts.TTL, tt.wantTTL)
+			}
+			if (opts.Proxied == nil) != (tt.wantProxied == nil) || opts.Proxied != nil && *opts.Proxied != *tt.wantProxied {
+				t.Errorf("Proxied = %v, want %v", fmtBool(opts.Proxied), fmtBool(tt.wantProxied))
+			}
+		})
+	}
+}
+
+// TestCreatedRecordDefaults checks that the records created without any of
+// the settings get the automatic TTL and leave the proxied state to
+// Cloudflare.
+func TestCreatedRecordDefaults(t *testing.T) {
+	api := &fakeDNS{}
+	opts := RecordSpec{}.options(ZoneSpec{}.options(updateOptions{CreateIfMissing: true}))
+	if _, err := updateRecord(context.Background(), api, "zone", "home.example.com", "A", "203.0.113.7", opts); err != nil {
+		t.Fatalf("updateRecord() error = %v", err)
+	}
+	if len(api.creates) != 1 {
+		t.Fatalf("updateRecord() made %d creates, want 1", len(api.creates))
+	}
+	if created := api.creates[0]; created.TTL != defaultTTL || created.Proxied != nil {
+		t.Errorf("created the record with TTL %d and proxied %v, want %d and unset", created.TTL, fmtBool(created.Proxied), defaultTTL)
+	}
+}
+
+func fmtBool(b *bool) any {
+	if b == nil {
+		return "unset"
+	}
+	return *b
+}
+
+// TestLoadConfigPartial checks that a config file completed by the flags, i.e.
+// by --domain, is loaded.
+func TestLoadConfigPartial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("zone: example.com\ntoken: secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.Zone != "example.com" || len(cfg.Records) != 0 {
+		t.Errorf("LoadConfig() = zone %q with %d records", cfg.Zone, len(cfg.Records))
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// confirmer asks the operator to confirm every change before it's made. It's
+// nil safe, a nil confirmer confirms everything.
+type confirmer struct {
+	mu  sync.Mutex
+	in  *bufio.Reader
+	out io.Writer
+	// all is set once the operator confirmed all of the changes.
+	all bool
+}
+
+// newTTYConfirmer returns the confirmer that prompts on out and reads the
+// answers from stdin, or nil when stdin isn't a terminal.
+func newTTYConfirmer(out io.Writer) *confirmer {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return &confirmer{in: bufio.NewReader(os.Stdin), out: out}
+}
+
+// confirm prints the planned change and reports whether the operator agreed
+// to it. The prompts of the records updated at the same time are serialized.
+func (c *confirmer) confirm(event changeEvent) bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.all {
+		return true
+	}
+
+	old := event.OldIP
+	if old == "" {
+		old = "(new record)"
+	}
+	for {
+		fmt.Fprintf(c.out, "%s %s: %s -> %s, apply? [y/N/a(ll)] ", event.Type, event.Record, old, event.NewIP)
+		answer, err := c.in.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true
+		case "a", "all":
+			c.all = true
+			return true
+		case "", "n", "no":
+			return false
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// noChangeLog collapses the repeated no change outcomes of a record, so that
+// a daemon with a short interval doesn't log one every cycle. Only the first
+// one is logged at info, the repeats at debug and their count once the
+// record changes or fails.
+type noChangeLog struct {
+	mu   sync.Mutex
+	runs map[string]noChangeRun
+}
+
+type noChangeRun struct {
+	count int
+	since time.Time
+}
+
+// noChangeLogs are the no change outcomes of all of the records.
+var noChangeLogs = &noChangeLog{runs: make(map[string]noChangeRun)}
+
+// log logs a no change outcome of the record.
+func (l *noChangeLog) log(entry *logrus.Entry, key, msg string) {
+	l.mu.Lock()
+	run, ok := l.runs[key]
+	if !ok {
+		run.since = time.Now()
+	}
+	run.count++
+	l.runs[key] = run
+	l.mu.Unlock()
+
+	if ok {
+		entry.WithField("repeat", run.count).Debug(msg)
+		return
+	}
+	entry.Info(msg)
+}
+
+// reset ends the run of the no change outcomes of the record once it changed
+// or failed, logging how long it lasted.
+func (l *noChangeLog) reset(entry *logrus.Entry, key string) {
+	l.mu.Lock()
+	run, ok := l.runs[key]
+	delete(l.runs, key)
+	l.mu.Unlock()
+
+	if ok && run.count > 1 {
+		since := time.Since(run.since).Round(time.Second)
+		entry.WithFields(logrus.Fields{
+			"event":    "record_unchanged_run",
+			"count":    run.count,
+			"duration": since,
+		}).Infof("no change (x%d over %v)", run.count, since)
+	}
+}
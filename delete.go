@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// Delete will remove the A and AAAA records of the names given as arguments,
+// or of the configured records, that are managed by the tool.
+func Delete(c *cli.Context) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return cli.Exit(err.Error(), ExitConfig)
+	}
+	zoneSpecs, err := commandZones(cfg)
+	if err != nil {
+		return cli.Exit(err.Error(), ExitConfig)
+	}
+	if len(c.Args().Slice()) == 0 && len(cfg.records()) == 0 {
+		return cli.Exit("missing the names of the records to delete", ExitConfig)
+	}
+
+	api, err := setupAPI(c, cfg)
+	if err != nil {
+		return err
+	}
+	var confirm *confirmer
+	if !c.Bool("yes") && !c.Bool("dry-run") {
+		confirm = newTTYConfirmer(c.App.ErrWriter)
+	}
+
+	zones := newZoneResolver(api)
+	var failed int
+	for _, zone := range zoneSpecs {
+		names := zoneNames(zone, c.Args().Slice())
+		if len(names) == 0 {
+			continue
+		}
+		zoneID := zone.ZoneID
+		if zoneID == "" {
+			if zoneID, err = zones.Resolve(ctx, zone.Zone); err != nil {
+				return cli.Exit(err.Error(), ExitAPI)
+			}
+		}
+
+		for _, name := range names {
+			for _, recordType := range []string{"A", "AAAA"} {
+				var records []cloudflare.DNSRecord
+				err := withRetry(ctx, defaultRetryPolicy, func() error {
+					var err error
+					records, _, err = api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{
+						Name: name,
+						Type: recordType,
+					})
+					return err
+				})
+				if err != nil {
+					logrus.WithError(err).WithFields(logrus.Fields{
+						"event": "delete_failed",
+						"name":  name,
+						"type":  recordType,
+					}).Error("could not list the dns records")
+					failed++
+					continue
+				}
+
+				for _, record := range records {
+					log := logrus.WithFields(logrus.Fields{
+						"name":    record.Name,
+						"type":    record.Type,
+						"content": record.Content,
+					})
+					if !c.Bool("force") && !isManaged(record, cfg) {
+						log.WithField("event", "delete_refused").Warn("record is not managed by cloudflare-ddns, use --force to delete it")
+						failed++
+						continue
+					}
+					if c.Bool("dry-run") {
+						log.WithField("event", "record_would_delete").Info("would delete record")
+						continue
+					}
+					if !confirm.confirm(changeEvent{Record: record.Name, Type: record.Type, OldIP: record.Content, NewIP: "(deleted)"}) {
+						log.WithField("event", "record_change_declined").Info("not deleting the record")
+						continue
+					}
+					err := withRetry(ctx, defaultRetryPolicy, func() error {
+						return api.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), record.ID)
+					})
+					if err != nil {
+						log.WithError(err).WithField("event", "delete_failed").Error("could not delete the record")
+						failed++
+						continue
+					}
+					log.WithField("event", "record_deleted").Info("deleted record")
+				}
+			}
+		}
+	}
+
+	if failed > 0 {
+		return cli.Exit(fmt.Sprintf("could not delete %d record(s)", failed), ExitFailure)
+	}
+	return nil
+}
+
+// commandZones returns the zones of the config, which all need a zone or a
+// zone ID for the commands that don't discover them by tags.
+func commandZones(cfg *Config) ([]ZoneSpec, error) {
+	zones := cfg.zoneSpecs()
+	if len(zones) == 0 {
+		return nil, errors.New("missing the zone, set --zone or --zone-id")
+	}
+	for _, z := range zones {
+		if z.Zone == "" && z.ZoneID == "" {
+			return nil, errors.New("missing the zone, set --zone or --zone-id")
+		}
+	}
+	return zones, nil
+}
+
+// zoneNames returns the names of the zone to delete: the ones of args within
+// the zone, all of them for a zone only known by its ID, or the names of the
+// configured records of the zone without args.
+func zoneNames(zone ZoneSpec, args []string) []string {
+	var names []string
+	if len(args) == 0 {
+		for _, r := range zone.Records {
+			if !slices.Contains(names, r.Name) {
+				names = append(names, r.Name)
+			}
+		}
+		return names
+	}
+	suffix := "." + normalizeRecordName(zone.Zone)
+	for _, name := range args {
+		n := normalizeRecordName(name)
+		if zone.Zone == "" || n == normalizeRecordName(zone.Zone) || strings.HasSuffix(n, suffix) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// isManaged reports whether the record carries one of the configured tags or
+// the configured comment, ignoring the parts of it that change with time.
+func isManaged(record cloudflare.DNSRecord, cfg *Config) bool {
+	for _, tag := range cfg.Tags {
+		if slices.Contains(record.Tags, tag) {
+			return true
+		}
+	}
+	if cfg.Comment != "" {
+		prefix, _, _ := strings.Cut(cfg.Comment, "{{")
+		return prefix != "" && strings.HasPrefix(record.Comment, prefix)
+	}
+	return false
+}
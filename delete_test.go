@@ -0,0 +1,31 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestZoneNames(t *testing.T) {
+	zone := ZoneSpec{Zone: "example.com", Records: []RecordSpec{
+		{Name: "home.example.com", Type: "A"},
+		{Name: "home.example.com", Type: "AAAA"},
+		{Name: "vpn.example.com", Type: "A"},
+	}}
+	tests := []struct {
+		name string
+		zone ZoneSpec
+		args []string
+		want []string
+	}{
+		{"records", zone, nil, []string{"home.example.com", "vpn.example.com"}},
+		{"args in zone", zone, []string{"Home.Example.com.", "example.com", "home.example.org", "badexample.com"}, []string{"Home.Example.com.", "example.com"}},
+		{"zone id", ZoneSpec{ZoneID: "zone"}, []string{"home.example.org"}, []string{"home.example.org"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := zoneNames(tt.zone, tt.args); !slices.Equal(got, tt.want) {
+				t.Errorf("zoneNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// normalizeTag returns the tag in the name:value form of the Cloudflare API,
+// it accepts name=value as well.
+func normalizeTag(tag string) string {
+	if name, value, ok := strings.Cut(tag, "="); ok && !strings.Contains(tag, ":") {
+		return name + ":" + value
+	}
+	return tag
+}
+
+// discoverTagged returns the A and AAAA records carrying the tag in all of the
+// zones the credentials can access, so that a record is added by tagging it
+// in the dashboard.
+func discoverTagged(ctx context.Context, api *cloudflare.API, tag string) ([]ZoneSpec, error) {
+	tag = normalizeTag(tag)
+
+	var zones cloudflare.ZonesResponse
+	err := withRetry(ctx, defaultRetryPolicy, func() error {
+		var err error
+		zones, err = api.ListZonesContext(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list the zones")
+	}
+
+	var specs []ZoneSpec
+	for _, zone := range zones.Result {
+		var records []cloudflare.DNSRecord
+		err := withRetry(ctx, defaultRetryPolicy, func() error {
+			var err error
+			records, _, err = api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zone.ID), cloudflare.ListDNSRecordsParams{
+				Tags: []string{tag},
+			})
+			return err
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not list the dns records of %s", zone.Name)
+		}
+
+		spec := ZoneSpec{Zone: zone.Name, ZoneID: zone.ID}
+		seen := map[string]bool{}
+		for _, record := range records {
+			key := recordKey(record.Type, record.Name)
+			if record.Type != "A" && record.Type != "AAAA" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			spec.Records = append(spec.Records, RecordSpec{Name: record.Name, Type: record.Type})
+		}
+		if len(spec.Records) == 0 {
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"event":   "records_discovered",
+			"zone":    zone.Name,
+			"tag":     tag,
+			"records": len(spec.Records),
+		}).Info("discovered the tagged records")
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// withoutConfigured drops the tagged records whose name and type are already
+// in the configured zones, so that the record isn't updated twice a cycle and
+// the settings of the configuration apply. An auto record covers both A and
+// AAAA.
+func withoutConfigured(tagged, configured []ZoneSpec) []ZoneSpec {
+	seen := map[string]bool{}
+	for _, zone := range configured {
+		for _, r := range zone.Records {
+			name := normalizeRecordName(r.Name)
+			if r.Type == "auto" {
+				seen[recordKey("A", name)] = true
+				seen[recordKey("AAAA", name)] = true
+				continue
+			}
+			seen[recordKey(r.Type, name)] = true
+		}
+	}
+
+	var specs []ZoneSpec
+	for _, zone := range tagged {
+		records := zone.Records[:0:0]
+		for _, r := range zone.Records {
+			if seen[recordKey(r.Type, normalizeRecordName(r.Name))] {
+				logrus.WithFields(logrus.Fields{
+					"event":  "record_already_configured",
+					"zone":   zone.Zone,
+					"record": r.Name,
+					"type":   r.Type,
+				}).Debug("skipping the tagged record, it's already configured")
+				continue
+			}
+			records = append(records, r)
+		}
+		if len(records) == 0 {
+			continue
+		}
+		zone.Records = records
+		specs = append(specs, zone)
+	}
+	return specs
+}
+
+// normalizeRecordName returns the name in the form compared between the
+// configuration and the API, lowercase and without the trailing dot.
+func normalizeRecordName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// The exit codes of the process, so that wrapper scripts can tell the kinds of
+// failures apart.
+const (
+	ExitOK      = 0
+	ExitFailure = 1
+	// ExitConfig means invalid flags or config file.
+	ExitConfig = 2
+	// ExitNetwork means the current address couldn't be detected or the
+	// network failed.
+	ExitNetwork = 3
+	// ExitAPI means the Cloudflare API rejected a request or the zone or the
+	// records weren't found.
+	ExitAPI = 4
+)
+
+// codedError classifies the wrapped error for ExitCode.
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+// withExitCode marks err with the exit code, nil stays nil.
+func withExitCode(err error, code int) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code: code, err: err}
+}
+
+// ExitCode maps err to the exit code of the process. Errors that weren't
+// marked with withExitCode are classified by their type.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	if errors.Is(err, ErrZoneNotFound) || errors.Is(err, ErrRecordNotFound) || errors.Is(err, ErrMultipleRecords) || errors.Is(err, ErrRecordLocked) {
+		return ExitAPI
+	}
+	// All of the error types of the Cloudflare client have the error codes of
+	// the API response.
+	var apiErr interface{ ErrorCodes() []int }
+	if errors.As(err, &apiErr) {
+		return ExitAPI
+	}
+	var netErr net.Error
+	var statusErr *httpStatusError
+	if errors.As(err, &netErr) || errors.As(err, &statusErr) {
+		return ExitNetwork
+	}
+	return ExitFailure
+}
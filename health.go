@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// health tracks the outcome of the update cycles for the liveness endpoint.
+type health struct {
+	mu          sync.Mutex
+	startedAt   time.Time
+	lastSuccess time.Time
+	lastError   error
+	// maxAge is how long ago the last successful cycle may have finished for
+	// the process to be considered healthy, zero means any success.
+	maxAge time.Duration
+}
+
+var ddnsHealth = &health{startedAt: time.Now()}
+
+// record stores the outcome of an update cycle that finished at t.
+func (h *health) record(err error, t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastError = err
+	if err == nil {
+		h.lastSuccess = t
+	}
+}
+
+// healthy reports whether a cycle succeeded within maxAge. Right after the
+// start the process gets maxAge to succeed for the first time.
+func (h *health) healthy(now time.Time) bool {
+	if h.maxAge == 0 {
+		return !h.lastSuccess.IsZero()
+	}
+	since := h.lastSuccess
+	if since.IsZero() {
+		since = h.startedAt
+	}
+	return now.Sub(since) <= h.maxAge
+}
+
+func (h *health) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var body struct {
+		Healthy     bool       `json:"healthy"`
+		LastSuccess *time.Time `json:"last_success,omitempty"`
+		LastError   string     `json:"last_error,omitempty"`
+	}
+	body.Healthy = h.healthy(time.Now())
+	if !h.lastSuccess.IsZero() {
+		body.LastSuccess = &h.lastSuccess
+	}
+	if h.lastError != nil {
+		body.LastError = h.lastError.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !body.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+// serveHealth exposes the /healthz endpoint on addr until ctx is done.
+func serveHealth(ctx context.Context, addr string, maxAge time.Duration) {
+	ddnsHealth.mu.Lock()
+	ddnsHealth.maxAge = maxAge
+	ddnsHealth.mu.Unlock()
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", ddnsHealth)
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		logrus.WithFields(logrus.Fields{
+			"event": "health_serving",
+			"addr":  addr,
+		}).Info("serving health checks")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.WithError(err).WithField("event", "health_failed").Error("health server failed")
+		}
+	}()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// hookCommands are the shell commands run before and after a record is
+// changed, they get the change in the DDNS_OLD_IP, DDNS_NEW_IP, DDNS_RECORD
+// and DDNS_TYPE environment variables.
+type hookCommands struct {
+	Pre, Post string
+	// AbortOnPreFailure skips the change when the pre hook fails.
+	AbortOnPreFailure bool
+}
+
+// runPre runs the pre hook and returns its error only when it should abort
+// the change.
+func (h hookCommands) runPre(ctx context.Context, event changeEvent) error {
+	err := runHook(ctx, "pre", h.Pre, event)
+	if err != nil && h.AbortOnPreFailure {
+		return errors.Wrap(err, "pre hook failed")
+	}
+	return nil
+}
+
+func (h hookCommands) runPost(ctx context.Context, event changeEvent) {
+	runHook(ctx, "post", h.Post, event)
+}
+
+func runHook(ctx context.Context, name, command string, event changeEvent) error {
+	if command == "" {
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"DDNS_OLD_IP="+event.OldIP,
+		"DDNS_NEW_IP="+event.NewIP,
+		"DDNS_RECORD="+event.Record,
+		"DDNS_TYPE="+event.Type,
+	)
+	out, err := cmd.CombinedOutput()
+
+	log := logFrom(ctx).WithFields(logrus.Fields{
+		"hook":   name,
+		"name":   event.Record,
+		"output": strings.TrimSpace(string(out)),
+	})
+	if cmd.ProcessState != nil {
+		log = log.WithField("status", cmd.ProcessState.ExitCode())
+	}
+	if err != nil {
+		log.WithError(err).WithField("event", "hook_failed").Warn("hook failed")
+		return err
+	}
+	log.WithField("event", "hook_finished").Info("hook finished")
+	return nil
+}
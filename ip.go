@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+)
+
+// IP will detect the current address with the configured IP source and print
+// only the address, without talking to Cloudflare.
+func IP(c *cli.Context) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return cli.Exit(err.Error(), ExitConfig)
+	}
+	if cfg.IPv6Suffix != "" {
+		if suffix, err := netip.ParseAddr(cfg.IPv6Suffix); err != nil || !suffix.Is6() {
+			return cli.Exit(fmt.Sprintf("invalid ipv6_suffix %q", cfg.IPv6Suffix), ExitConfig)
+		}
+	}
+	configureRuntime(c)
+
+	var proto RequestProto
+	switch p := c.String("proto"); p {
+	case "":
+		proto = RequestProtoDefault
+	case "4":
+		proto = RequestProtoIP4
+	case "6":
+		proto = RequestProtoIP6
+	default:
+		return cli.Exit(fmt.Sprintf("invalid --proto %q, must be 4 or 6", p), ExitConfig)
+	}
+
+	provider, err := cfg.ipProvider(cfg.Source, proto)
+	if err != nil {
+		return cli.Exit(err.Error(), ExitConfig)
+	}
+	ip, err := provider.currentIP(ctx, proto)
+	if err != nil {
+		return withExitCode(err, ExitNetwork)
+	}
+	fmt.Fprintln(c.App.Writer, ip)
+	return nil
+}
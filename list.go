@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/urfave/cli/v2"
+)
+
+// List will print the DNS records of the zones, which helps finding the exact
+// names and types for the config.
+func List(c *cli.Context) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return cli.Exit(err.Error(), ExitConfig)
+	}
+	zoneSpecs, err := commandZones(cfg)
+	if err != nil {
+		return cli.Exit(err.Error(), ExitConfig)
+	}
+
+	api, err := setupAPI(c, cfg)
+	if err != nil {
+		return err
+	}
+
+	zones := newZoneResolver(api)
+	w := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ZONE\tNAME\tTYPE\tCONTENT\tTTL\tPROXIED")
+	for _, zone := range zoneSpecs {
+		zoneID := zone.ZoneID
+		if zoneID == "" {
+			if zoneID, err = zones.Resolve(ctx, zone.Zone); err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+		}
+
+		var records []cloudflare.DNSRecord
+		err := withRetry(ctx, defaultRetryPolicy, func() error {
+			var err error
+			records, _, err = api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{
+				Type: c.String("type"),
+			})
+			return err
+		})
+		if err != nil {
+			return cli.Exit(fmt.Sprintf("could not list the dns records: %v", err), 1)
+		}
+
+		name := zone.Zone
+		if name == "" {
+			name = zoneID
+		}
+		for _, r := range records {
+			ttl := fmt.Sprint(r.TTL)
+			if r.TTL == 1 {
+				ttl = "auto"
+			}
+			proxied := r.Proxied != nil && *r.Proxied
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\n", name, r.Name, r.Type, r.Content, ttl, proxied)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+type logKey struct{}
+
+// withLog returns the context that carries the log entry, i.e. with the
+// fields of the zone and the record being updated.
+func withLog(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, logKey{}, entry)
+}
+
+// logFrom returns the log entry of the context or the standard logger.
+func logFrom(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(logKey{}).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
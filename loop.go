@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// minInterval is the shortest interval RunLoop accepts, so that a typo in the
+// interval doesn't hammer the Cloudflare API.
+var minInterval = time.Minute
+
+// shutdownGrace is how long an update cycle that is running when the process
+// is asked to stop may take to finish.
+var shutdownGrace = 10 * time.Second
+
+// loopJitter is the largest fraction of the interval that is randomly added to
+// each wait, so that multiple instances don't synchronize.
+const loopJitter = 0.1
+
+// RunLoop calls fn immediately and then on every interval until ctx is done.
+// A receive from trigger runs the next cycle right away, the interval then
+// starts over. A cycle that is already running is allowed to finish within
+// shutdownGrace.
+func RunLoop(ctx context.Context, interval time.Duration, trigger <-chan struct{}, fn func(context.Context) error) error {
+	if interval < minInterval {
+		logrus.WithFields(logrus.Fields{
+			"event":        "interval_raised",
+			"interval":     interval,
+			"min_interval": minInterval,
+		}).Warn("interval is below the minimum, using the minimum")
+		interval = minInterval
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.WithField("event", "shutdown").Info("shutting down")
+			return nil
+		case <-timer.C:
+		case <-trigger:
+			timer.Stop()
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+
+		start := time.Now()
+		if err := runWithGrace(ctx, fn); err != nil {
+			logrus.WithError(err).WithField("event", "cycle_failed").Error("update cycle failed")
+		} else {
+			logrus.WithFields(logrus.Fields{
+				"event":    "cycle_finished",
+				"duration": time.Since(start),
+			}).Info("update cycle finished")
+		}
+
+		jitter := time.Duration(rand.Int63n(int64(float64(interval)*loopJitter) + 1))
+		timer.Reset(time.Until(start.Add(interval + jitter)))
+	}
+}
+
+// sleepJitter waits for a random duration up to limit, so that many instances
+// starting at once don't all hit the providers and Cloudflare together. It
+// returns false when ctx is done first.
+func sleepJitter(ctx context.Context, limit time.Duration) bool {
+	d := time.Duration(rand.Int63n(int64(limit) + 1))
+	logrus.WithFields(logrus.Fields{
+		"event": "startup_delay",
+		"delay": d,
+	}).Info("delaying the first update")
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// cycleStep remembers the step of the update cycle in progress, so that a
+// cycle that runs out of time can tell where it got stuck. It's nil safe.
+type cycleStep struct {
+	mu   sync.Mutex
+	name string
+}
+
+func (s *cycleStep) set(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+func (s *cycleStep) get() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.name
+}
+
+// runWithGrace calls fn with a context that is cancelled only shutdownGrace
+// after ctx is done, so that an in-flight change isn't left half applied.
+func runWithGrace(ctx context.Context, fn func(context.Context) error) error {
+	fnCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+
+	stop := context.AfterFunc(ctx, func() {
+		logrus.WithFields(logrus.Fields{
+			"event": "shutdown",
+			"grace": shutdownGrace,
+		}).Info("shutting down, waiting for the update cycle to finish")
+		time.AfterFunc(shutdownGrace, cancel)
+	})
+	defer stop()
+
+	return fn(fnCtx)
+}
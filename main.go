@@ -4,8 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/netip"
 	"os"
+	"os/signal"
 	"slices"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/cloudflare/cloudflare-go"
 	"github.com/sirupsen/logrus"
@@ -20,63 +25,441 @@ var (
 
 // Before will setup the logger.
 func Before(c *cli.Context) error {
-	if c.Bool("json") {
+	switch format := c.String("log-format"); {
+	case c.Bool("json") || format == "json":
 		// Configure the JSON logger if enabled.
 		logrus.SetFormatter(&logrus.JSONFormatter{})
+	case format != "text":
+		return cli.Exit(fmt.Sprintf("unknown log format %q, must be text or json", format), ExitConfig)
+	case c.Bool("no-color"):
+		// The colors are only used on a terminal anyway.
+		logrus.SetFormatter(&logrus.TextFormatter{DisableColors: true})
 	}
 
+	switch output := c.String("log-output"); output {
+	case "", "stderr":
+	case "stdout":
+		logrus.SetOutput(os.Stdout)
+	default:
+		f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return cli.Exit(fmt.Sprintf("could not open the log file: %v", err), ExitConfig)
+		}
+		logrus.SetOutput(f)
+	}
+
+	level, err := logrus.ParseLevel(c.String("log-level"))
+	if err != nil {
+		return cli.Exit(err.Error(), ExitConfig)
+	}
+	logrus.SetLevel(level)
+
 	if c.Bool("debug") {
 		// Set the debug log level if enabled.
 		logrus.SetLevel(logrus.DebugLevel)
 	}
+	if c.Bool("quiet") {
+		logrus.SetFormatter(quietFormatter{logrus.StandardLogger().Formatter})
+	}
 
 	return nil
 }
 
+// loadConfig will read the config file if one is given and override its
+// values with the flags that are explicitly set.
+func loadConfig(c *cli.Context) (*Config, error) {
+	cfg := &Config{}
+	if path := c.String("config"); path != "" {
+		var err error
+		if cfg, err = LoadConfig(path); err != nil {
+			return nil, err
+		}
+	}
+
+	setString := func(name string, dst *string) {
+		if c.IsSet(name) || *dst == "" {
+			*dst = c.String(name)
+		}
+	}
+	setStrings := func(name string, dst *[]string) {
+		if c.IsSet(name) || len(*dst) == 0 {
+			*dst = c.StringSlice(name)
+		}
+	}
+
+	setString("token", &cfg.Token)
+	setString("key", &cfg.Key)
+	setString("email", &cfg.Email)
+	setString("token-file", &cfg.TokenFile)
+	setString("key-file", &cfg.KeyFile)
+	setString("email-file", &cfg.EmailFile)
+	setString("zone", &cfg.Zone)
+	setString("zone-id", &cfg.ZoneID)
+	setString("source", &cfg.Source)
+	setStrings("ipurl", &cfg.IPURLs)
+	setStrings("ip4url", &cfg.IP4URLs)
+	setStrings("ip6url", &cfg.IP6URLs)
+	setString("state-file", &cfg.StateFile)
+	setString("ip-cache-file", &cfg.IPCacheFile)
+	setString("bind-interface", &cfg.BindInterface)
+	setString("bind-address", &cfg.BindAddress)
+	setString("ipv6-suffix", &cfg.IPv6Suffix)
+	setStrings("allowed-cidr", &cfg.AllowedCIDRs)
+	setStrings("denied-cidr", &cfg.DeniedCIDRs)
+	setStrings("fallback-ip", &cfg.FallbackIPs)
+	setString("comment", &cfg.Comment)
+	setString("tag-selector", &cfg.TagSelector)
+	setString("user-agent", &cfg.UserAgent)
+	if c.IsSet("tag") {
+		cfg.Tags = c.StringSlice("tag")
+	}
+	if c.IsSet("ipv6-prefix-len") {
+		cfg.IPv6PrefixLen = c.Int("ipv6-prefix-len")
+	}
+	if c.IsSet("strict-ip") {
+		cfg.StrictIP = c.Bool("strict-ip")
+	}
+	if c.IsSet("create") {
+		cfg.CreateIfMissing = c.Bool("create")
+	}
+	if c.IsSet("ttl") {
+		cfg.TTL = c.Int("ttl")
+	}
+	if c.IsSet("proxied") {
+		proxied := c.Bool("proxied")
+		cfg.Proxied = &proxied
+	}
+	if c.IsSet("update-all") {
+		cfg.UpdateAll = c.Bool("update-all")
+	}
+	if c.IsSet("interval") {
+		cfg.Interval = c.Duration("interval")
+	}
+	if c.IsSet("ip-cache-ttl") {
+		cfg.IPCacheTTL = c.Duration("ip-cache-ttl")
+	}
+	if c.IsSet("ip-cache-readonly") {
+		cfg.IPCacheReadOnly = c.Bool("ip-cache-readonly")
+	}
+
+	if c.IsSet("domain") || len(cfg.Records) == 0 {
+		cfg.Records = nil
+		update := c.StringSlice("update")
+		for _, name := range strings.Split(c.String("domain"), ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if slices.Contains(update, "ip4") {
+				cfg.Records = append(cfg.Records, RecordSpec{Name: name, Type: "A"})
+			}
+			if slices.Contains(update, "ip6") {
+				cfg.Records = append(cfg.Records, RecordSpec{Name: name, Type: "AAAA"})
+			}
+			if slices.Contains(update, "auto") {
+				cfg.Records = append(cfg.Records, RecordSpec{Name: name, Type: "auto"})
+			}
+		}
+	}
+
+	if id := c.String("record-id"); id != "" {
+		if len(cfg.Records) != 1 {
+			return nil, errors.New("--record-id needs a single record, set --domain to one name and --update to ip4 or ip6")
+		}
+		cfg.Records[0].ID = id
+	}
+
+	// Anyone reaching the listener could point the records at their address.
+	if c.String("listen") != "" && c.String("listen-token") == "" {
+		return nil, errors.New("--listen needs a --listen-token, the pushes set the addresses of the records")
+	}
+
+	if err := cfg.readSecrets(); err != nil {
+		return nil, err
+	}
+	cfg.applyDefaults()
+	return cfg, nil
+}
+
+// setup will load and validate the config, create the Cloudflare client and
+// configure the retries and timeouts.
+func setup(c *cli.Context) (*Config, *cloudflare.API, error) {
+	cfg, err := loadConfig(c)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		return nil, nil, cli.Exit(err.Error(), ExitConfig)
+	}
+
+	api, err := setupAPI(c, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, api, nil
+}
+
+// setupAPI will create the Cloudflare client and configure the retries and
+// timeouts.
+func setupAPI(c *cli.Context, cfg *Config) (*cloudflare.API, error) {
+	api, err := newCloudflareClient(authConfig{
+		Token: cfg.Token,
+		Key:   cfg.Key,
+		Email: cfg.Email,
+	}, cloudflare.UsingRateLimit(c.Float64("rate-limit")))
+	if err != nil {
+		return nil, cli.Exit(err.Error(), ExitConfig)
+	}
+	configureRuntime(c)
+	return api, nil
+}
+
+// configureRuntime will set the retries, the timeouts and the circuit breaker
+// of the IP providers from the flags.
+func configureRuntime(c *cli.Context) {
+	defaultRetryPolicy = retryPolicy{
+		MaxAttempts: c.Int("retries"),
+		BaseDelay:   c.Duration("retry-delay"),
+		MaxDelay:    c.Duration("retry-max-delay"),
+	}
+
+	ipProviderTimeout = c.Duration("ip-timeout")
+	dialFallbackDelay = c.Duration("dial-fallback-delay")
+	minInterval = c.Duration("min-interval")
+	shutdownGrace = c.Duration("shutdown-grace")
+	providerBreaker.Threshold = c.Int("breaker-threshold")
+	providerBreaker.Cooldown = c.Duration("breaker-cooldown")
+}
+
+// updateZone resolves the ID of the zone unless it's configured and updates
+// its records.
+func updateZone(ctx context.Context, api *cloudflare.API, zones *zoneResolver, zone ZoneSpec, defaultSource string, providers map[string]sourceProviders, opts updateOptions) error {
+	ctx = withLog(ctx, logFrom(ctx).WithField("zone", zone.name()))
+
+	var err error
+	zoneID := zone.ZoneID
+	if zoneID == "" {
+		opts.Step.set("resolving the zone " + zone.Zone)
+		zoneID, err = zones.Resolve(ctx, zone.Zone)
+	}
+	if err != nil {
+		for range zone.Records {
+			opts.Summary.add(false, err)
+		}
+		return err
+	}
+
+	opts.ZoneName = zone.Zone
+	err = UpdateRecordsBySource(ctx, api, zoneID, zone.Records, defaultSource, providers, zone.options(opts))
+	zones.InvalidateOnError(zone.Zone, err)
+	return err
+}
+
+// resolveZones looks up the IDs of the zones known by name only. The zones
+// that can't be resolved are dropped and their errors returned.
+func resolveZones(ctx context.Context, zones *zoneResolver, specs []ZoneSpec, opts updateOptions) ([]ZoneSpec, []error) {
+	var resolved []ZoneSpec
+	var errs []error
+	for _, zone := range specs {
+		if zone.ZoneID == "" {
+			opts.Step.set("resolving the zone " + zone.Zone)
+			id, err := zones.Resolve(ctx, zone.Zone)
+			if err != nil {
+				for range zone.Records {
+					opts.Summary.add(false, err)
+				}
+				errs = append(errs, fmt.Errorf("%s: %w", zone.name(), err))
+				continue
+			}
+			zone.ZoneID = id
+		}
+		resolved = append(resolved, zone)
+	}
+	return resolved, errs
+}
+
 // Action will perform the update operation.
 func Action(c *cli.Context) error {
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	var api *cloudflare.API
-
-	var err error
+	cfg, api, err := setup(c)
+	if err != nil {
+		return err
+	}
 
-	if c.String("token") != "" {
-		api, err = cloudflare.NewWithAPIToken(c.String("token"))
+	providers, err := cfg.sourceProviders()
+	if err != nil {
+		return cli.Exit(err.Error(), ExitConfig)
+	}
+	if content := c.String("force-content"); content != "" {
+		ip, err := netip.ParseAddr(content)
 		if err != nil {
-			return cli.Exit(err.Error(), 1)
+			return cli.Exit(fmt.Sprintf("invalid --force-content: %v", err), ExitConfig)
 		}
-	} else if c.String("key") != "" && c.String("email") != "" {
-		api, err = cloudflare.New(c.String("key"), c.String("email"))
-		if err != nil {
-			return cli.Exit(err.Error(), 1)
+		logrus.WithFields(logrus.Fields{
+			"event": "manual_override",
+			"ip":    ip,
+		}).Warn("manual override in effect, the address is not detected")
+		static := ipProvider{Source: staticIPSource{IP: ip}}
+		for selector := range providers {
+			providers[selector] = sourceProviders{IP4: static, IP6: static, Any: static}
 		}
-	} else {
-		return cli.Exit("either --key and --email or --token must be defined", 1)
 	}
 
-	var errs []error
+	zones := newZoneResolver(api)
+	zones.wait = c.Duration("wait-for-zone")
+
+	cache, err := loadLastKnownIP(cfg.StateFile)
+	if err != nil {
+		return cli.Exit(err.Error(), ExitConfig)
+	}
+	opts := updateOptions{
+		CreateIfMissing:   cfg.CreateIfMissing,
+		UpdateAll:         cfg.UpdateAll,
+		TTL:               cfg.TTL,
+		Proxied:           cfg.Proxied,
+		Cache:             cache,
+		MinChangeInterval: c.Duration("min-change-interval"),
+		DryRun:            c.Bool("dry-run"),
+		VerifyDNS:         c.Bool("verify-dns"),
+		Concurrency:       c.Int("concurrency"),
+		AllowPrivate:      c.Bool("allow-private"),
+		IPv6Optional:      c.Bool("ipv6-optional"),
+		GuardChanges:      c.Bool("ip-guard") && !c.Bool("force"),
+		ToggleProxy:       c.Bool("toggle-proxy"),
+		Comment:           cfg.Comment,
+		Tags:              cfg.Tags,
+		Hooks: hookCommands{
+			Pre:               c.String("pre-hook"),
+			Post:              c.String("post-hook"),
+			AbortOnPreFailure: c.Bool("pre-hook-abort"),
+		},
+	}
+	// The prefixes were validated with the config.
+	switch {
+	case c.Bool("ipv4-only") && c.Bool("ipv6-only"):
+		return cli.Exit("--ipv4-only and --ipv6-only are mutually exclusive", ExitConfig)
+	case c.Bool("ipv4-only"):
+		opts.Family = RequestProtoIP4
+	case c.Bool("ipv6-only"):
+		opts.Family = RequestProtoIP6
+	}
+	interval := cfg.Interval
+	if c.String("listen") != "" && interval == 0 {
+		interval = pushPollInterval
+	}
+	daemon := interval > 0 && !c.Bool("once")
+	// Only the single runs by hand are confirmed, the daemon would block.
+	if !c.Bool("yes") && !c.Bool("dry-run") && !daemon {
+		opts.Confirm = newTTYConfirmer(c.App.ErrWriter)
+	}
+	opts.AllowedCIDRs, _ = parsePrefixes(cfg.AllowedCIDRs)
+	opts.DeniedCIDRs, _ = parsePrefixes(cfg.DeniedCIDRs)
+	if opts.Writers, err = cfg.writers(cloudflare.UsingRateLimit(c.Float64("rate-limit"))); err != nil {
+		return cli.Exit(err.Error(), ExitConfig)
+	}
+	if url := c.String("notify-webhook"); url != "" {
+		opts.Notifier = newWebhookNotifier(url)
+	}
+
+	update := func(ctx context.Context) error {
+		step := &cycleStep{}
+		summary := &updateSummary{}
+		opts := opts
+		opts.Step = step
+		opts.Summary = summary
+		defer func() {
+			// The uneventful cycles print nothing in the quiet mode.
+			if !c.Bool("quiet") || summary.Changed > 0 || summary.Errors > 0 {
+				fmt.Fprintln(c.App.Writer, summary)
+			}
+		}()
 
-	update := c.StringSlice("update")
-	if slices.Contains(update, "ip4") {
-		if err := UpdateDomain4(ctx, api, c.String("zone"), c.String("domain"), c.String("ipurl")); err != nil {
-			errs = append(errs, err)
+		// Waiting for a zone that was just created isn't cut short by the
+		// cycle deadline.
+		zoneSpecs, errs := resolveZones(ctx, zones, cfg.zoneSpecs(), opts)
+
+		cycleTimeout := c.Duration("cycle-timeout")
+		// The runs that prompt for the changes wait for the operator instead.
+		if opts.Confirm == nil {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cycleTimeout)
+			defer cancel()
+		}
+		ctx = withIPMemo(ctx)
+
+		if cfg.TagSelector != "" {
+			step.set("discovering the records tagged " + cfg.TagSelector)
+			tagged, err := discoverTagged(ctx, api, cfg.TagSelector)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			zoneSpecs = append(zoneSpecs, withoutConfigured(tagged, zoneSpecs)...)
+		}
+		for _, zone := range zoneSpecs {
+			if err := updateZone(ctx, api, zones, zone, cfg.Source, providers, opts); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", zone.name(), err))
+			}
+		}
+		err := errors.Join(errs...)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			logrus.WithFields(logrus.Fields{
+				"event":   "cycle_timeout",
+				"timeout": cycleTimeout,
+				"step":    step.get(),
+			}).Error("update cycle timed out")
 		}
+		ddnsHealth.record(err, time.Now())
+		if err == nil {
+			ddnsMetrics.recordSuccess(time.Now())
+		}
+		return err
+	}
+
+	if addr := c.String("metrics-addr"); addr != "" {
+		serveMetrics(ctx, addr)
+	}
+	if addr := c.String("health-addr"); addr != "" {
+		serveHealth(ctx, addr, time.Duration(c.Int("health-max-intervals"))*max(interval, minInterval))
+	}
+
+	if jitter := c.Duration("startup-jitter"); jitter > 0 && !sleepJitter(ctx, jitter) {
+		logrus.WithField("event", "shutdown").Info("shutting down")
+		return nil
+	}
+
+	if !daemon {
+		return runWithGrace(ctx, update)
 	}
-	if slices.Contains(update, "ip6") {
-		if err := UpdateDomain6(ctx, api, c.String("zone"), c.String("domain"), c.String("ipurl")); err != nil {
-			errs = append(errs, err)
+	var trigger chan struct{}
+	if addr := c.String("listen"); addr != "" {
+		push := newPushListener(c.String("listen-token"))
+		for selector, p := range providers {
+			p.IP4.Source = pushedIPSource{Live: p.IP4.Source}
+			p.IP6.Source = pushedIPSource{Live: p.IP6.Source}
+			p.Any.Source = pushedIPSource{Live: p.Any.Source}
+			providers[selector] = p
+		}
+		next := update
+		update = func(ctx context.Context) error {
+			return next(withPushed(ctx, push.take()))
 		}
+		trigger = push.trigger
+		servePush(ctx, addr, push)
 	}
-	return errors.Join(errs...)
+	return RunLoop(ctx, interval, trigger, update)
 }
 
 func main() {
 	app := cli.NewApp()
 	app.Name = "cloudflare-ddns"
-	app.Version = fmt.Sprintf("%v, commit %v, built at %v", version, commit, date)
+	app.Version = readBuildInfo().String()
 	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			EnvVars: []string{"CF_CONFIG"},
+			Usage:   "YAML config file, the flags that are set explicitly take precedence over it.",
+		},
 		&cli.StringFlag{
 			Name:    "token",
 			EnvVars: []string{"CF_API_TOKEN"},
@@ -93,42 +476,466 @@ func main() {
 			Usage:   "Email address associated with your Cloudflare account.",
 		},
 		&cli.StringFlag{
-			Name:     "zone",
-			Required: true,
-			EnvVars:  []string{"CF_ZONE"},
-			Usage:    "Zone",
+			Name:    "token-file",
+			EnvVars: []string{"CF_API_TOKEN_FILE"},
+			Usage:   "File to read the API Token from, i.e. a Docker or Kubernetes secret.",
 		},
 		&cli.StringFlag{
-			Name:     "domain",
-			Required: true,
-			EnvVars:  []string{"CF_DOMAIN"},
-			Usage:    "Comma separated domain names that should be updated. (i.e. mypage.example.com OR example.com)",
+			Name:    "key-file",
+			EnvVars: []string{"CF_API_KEY_FILE"},
+			Usage:   "File to read the API Key from.",
+		},
+		&cli.StringFlag{
+			Name:    "email-file",
+			EnvVars: []string{"CF_API_EMAIL_FILE"},
+			Usage:   "File to read the email address from.",
+		},
+		&cli.StringFlag{
+			Name:    "zone",
+			EnvVars: []string{"CF_ZONE"},
+			Usage:   "Zone",
+		},
+		&cli.StringFlag{
+			Name:    "zone-id",
+			EnvVars: []string{"CF_ZONE_ID"},
+			Usage:   "Zone ID, skips looking the zone up by name which requires the Zone.Zone read permission. Takes precedence over --zone.",
+		},
+		&cli.StringFlag{
+			Name:    "domain",
+			EnvVars: []string{"CF_DOMAIN"},
+			Usage:   "Comma separated domain names that should be updated. (i.e. mypage.example.com OR example.com)",
+		},
+		&cli.StringFlag{
+			Name:    "record-id",
+			EnvVars: []string{"CF_RECORD_ID"},
+			Usage:   "ID of the record of --domain, it's fetched directly instead of listing the records, i.e. for the tokens that can't list them.",
+		},
+		&cli.StringFlag{
+			Name:    "source",
+			Value:   "http",
+			EnvVars: []string{"CF_IP_SOURCE"},
+			Usage:   "Where to detect the IP address: http (the --ipurl endpoints), an http(s):// URL of a single endpoint, interface:<name> (i.e. interface:eth0), dns (OpenDNS/Google resolvers), stun[:host:port] (i.e. stun:stun.l.google.com:19302) or upnp (the WAN address of the router, ipv4 only).",
+		},
+		&cli.BoolFlag{
+			Name:    "ip-guard",
+			EnvVars: []string{"CF_IP_GUARD"},
+			Usage:   "Refuse to move a record to an address outside of the /8 (IPv4) or /32 (IPv6) of its current address, i.e. when a provider reports a wrong but valid address.",
+		},
+		&cli.BoolFlag{
+			Name:    "toggle-proxy",
+			EnvVars: []string{"CF_TOGGLE_PROXY"},
+			Usage:   "Set the proxied A and AAAA records to DNS only while their address is changed and proxy them again afterwards.",
+		},
+		&cli.BoolFlag{
+			Name:    "force",
+			EnvVars: []string{"CF_FORCE"},
+			Usage:   "Apply the changes refused by --ip-guard.",
 		},
 		&cli.StringFlag{
+			Name:    "force-content",
+			EnvVars: []string{"CF_FORCE_CONTENT"},
+			Usage:   "Point the records at this address instead of detecting it, i.e. during maintenance. Use --update to select its family.",
+		},
+		&cli.StringSliceFlag{
 			Name:    "ipurl",
-			Value:   "https://domains.google.com/checkip",
 			EnvVars: []string{"CF_IP_URL"},
-			Usage:   "Alternative ip address service endpoint.",
+			Usage:   "Alternative ip address service endpoints, tried in order until one succeeds. Defaults to the Cloudflare trace (https://1.1.1.1/cdn-cgi/trace), ipify and icanhazip.",
+		},
+		&cli.StringSliceFlag{
+			Name:    "ip4url",
+			EnvVars: []string{"CF_IP4_URL"},
+			Usage:   "Overrides --ipurl for the IP4 address.",
+		},
+		&cli.StringSliceFlag{
+			Name:    "ip6url",
+			EnvVars: []string{"CF_IP6_URL"},
+			Usage:   "Overrides --ipurl for the IP6 address.",
+		},
+		&cli.DurationFlag{
+			Name:    "dial-fallback-delay",
+			EnvVars: []string{"CF_DIAL_FALLBACK_DELAY"},
+			Usage:   "How long to wait for the IPv6 connection to an ip address service endpoint before also trying IPv4 when the family isn't forced (default 300ms). A negative delay disables the fallback.",
+		},
+		&cli.StringFlag{
+			Name:    "user-agent",
+			EnvVars: []string{"CF_USER_AGENT"},
+			Usage:   "User-Agent of the requests to the ip address service endpoints (default cloudflare-ddns/<version>).",
+		},
+		&cli.DurationFlag{
+			Name:    "ip-timeout",
+			Value:   10 * time.Second,
+			EnvVars: []string{"CF_IP_TIMEOUT"},
+			Usage:   "Timeout of a single request to an ip address service endpoint.",
+		},
+		&cli.IntFlag{
+			Name:    "breaker-threshold",
+			Value:   3,
+			EnvVars: []string{"CF_BREAKER_THRESHOLD"},
+			Usage:   "Skip an --ipurl endpoint after this many consecutive failures, 0 never skips.",
+		},
+		&cli.DurationFlag{
+			Name:    "breaker-cooldown",
+			Value:   5 * time.Minute,
+			EnvVars: []string{"CF_BREAKER_COOLDOWN"},
+			Usage:   "How long a failing --ipurl endpoint is skipped before it's tried again.",
+		},
+		&cli.StringFlag{
+			Name:    "bind-interface",
+			EnvVars: []string{"CF_BIND_INTERFACE"},
+			Usage:   "Make the requests to the ip address service endpoints through this network interface (Linux only).",
+		},
+		&cli.StringFlag{
+			Name:    "bind-address",
+			EnvVars: []string{"CF_BIND_ADDRESS"},
+			Usage:   "Make the requests to the ip address service endpoints from this local address.",
+		},
+		&cli.StringFlag{
+			Name:    "ipv6-suffix",
+			EnvVars: []string{"CF_IPV6_SUFFIX"},
+			Usage:   "Replace the host part of the detected IPv6 address with this suffix (i.e. ::1234) to follow a changing prefix.",
+		},
+		&cli.IntFlag{
+			Name:    "ipv6-prefix-len",
+			Value:   64,
+			EnvVars: []string{"CF_IPV6_PREFIX_LEN"},
+			Usage:   "Length of the detected prefix that's kept when --ipv6-suffix is set.",
+		},
+		&cli.BoolFlag{
+			Name:    "strict-ip",
+			EnvVars: []string{"CF_STRICT_IP"},
+			Usage:   "Require two different ip address service endpoints to agree on the address.",
 		},
 		&cli.StringSliceFlag{
 			Name:    "update",
 			Value:   cli.NewStringSlice("ip4", "ip6"),
 			EnvVars: []string{"CF_IP_UPDATE"},
-			Usage:   "ip4 or ip6",
+			Usage:   "ip4, ip6 or auto (the A or AAAA record by the family of the address the provider returns)",
+		},
+		&cli.BoolFlag{
+			Name:    "allow-private",
+			EnvVars: []string{"CF_ALLOW_PRIVATE"},
+			Usage:   "Allow pointing the records at private, loopback or link-local addresses, i.e. for a LAN only zone.",
+		},
+		&cli.StringSliceFlag{
+			Name:    "allowed-cidr",
+			EnvVars: []string{"CF_ALLOWED_CIDRS"},
+			Usage:   "Only update the records when the detected address is in one of these prefixes of its family, i.e. the prefixes of the ISP.",
+		},
+		&cli.StringSliceFlag{
+			Name:    "denied-cidr",
+			EnvVars: []string{"CF_DENIED_CIDRS"},
+			Usage:   "Never point the records at an address in these prefixes, i.e. of a VPN.",
+		},
+		&cli.StringSliceFlag{
+			Name:    "fallback-ip",
+			EnvVars: []string{"CF_FALLBACK_IPS"},
+			Usage:   "Point the records at this address, one per family, when the current address can't be detected, i.e. the one of a backup host.",
+		},
+		&cli.BoolFlag{
+			Name:    "create",
+			EnvVars: []string{"CF_CREATE"},
+			Usage:   "Create the DNS record if it does not exist.",
+		},
+		&cli.DurationFlag{
+			Name:    "interval",
+			EnvVars: []string{"CF_INTERVAL"},
+			Usage:   "Keep running and update the records on this interval (i.e. 5m). Runs once if not set.",
+		},
+		&cli.BoolFlag{
+			Name:    "once",
+			EnvVars: []string{"CF_ONCE"},
+			Usage:   "Update the records once and exit even when --interval is set. Exits with 2 on config, 3 on network and 4 on Cloudflare API errors.",
+		},
+		&cli.DurationFlag{
+			Name:    "startup-jitter",
+			EnvVars: []string{"CF_STARTUP_JITTER"},
+			Usage:   "Wait a random duration up to this before the first update, i.e. 30s, so that devices booting together don't update at once.",
+		},
+		&cli.DurationFlag{
+			Name:    "min-interval",
+			Value:   time.Minute,
+			EnvVars: []string{"CF_MIN_INTERVAL"},
+			Usage:   "Shortest accepted --interval, a lower one is raised to it with a warning.",
+		},
+		&cli.DurationFlag{
+			Name:    "cycle-timeout",
+			Value:   30 * time.Second,
+			EnvVars: []string{"CF_CYCLE_TIMEOUT"},
+			Usage:   "Abandon an update cycle, the address detection and all of the record updates, that takes longer than this. Not applied while prompting for the changes.",
+		},
+		&cli.DurationFlag{
+			Name:    "shutdown-grace",
+			Value:   10 * time.Second,
+			EnvVars: []string{"CF_SHUTDOWN_GRACE"},
+			Usage:   "How long a running update may take to finish after SIGINT or SIGTERM.",
+		},
+		&cli.BoolFlag{
+			Name:    "update-all",
+			EnvVars: []string{"CF_UPDATE_ALL"},
+			Usage:   "Update all of the records matching the name and type instead of failing when there are more than one (i.e. round-robin).",
+		},
+		&cli.IntFlag{
+			Name:    "ttl",
+			EnvVars: []string{"CF_TTL"},
+			Usage:   "TTL of the records in seconds (60-86400) or 1 for automatic. Keeps the current TTL if not set.",
+		},
+		&cli.BoolFlag{
+			Name:    "proxied",
+			EnvVars: []string{"CF_PROXIED"},
+			Usage:   "Force the records to be proxied (--proxied) or DNS only (--proxied=false). Keeps the current state if not set.",
+		},
+		&cli.StringFlag{
+			Name:    "comment",
+			EnvVars: []string{"CF_COMMENT"},
+			Usage:   "Comment set on the written records, {{.Time}} is replaced with the time of the change, i.e. \"managed by cloudflare-ddns at {{.Time}}\".",
+		},
+		&cli.StringSliceFlag{
+			Name:    "tag",
+			EnvVars: []string{"CF_TAGS"},
+			Usage:   "Replace the tags of the written records, i.e. managed:ddns. The current tags are kept when unset.",
+		},
+		&cli.StringFlag{
+			Name:    "tag-selector",
+			EnvVars: []string{"CF_TAG_SELECTOR"},
+			Usage:   "Also update the A and AAAA records tagged with this tag (i.e. ddns:home) in all of the zones the credentials can access. Keep the tag in --tag if it's set.",
+		},
+		&cli.StringFlag{
+			Name:    "state-file",
+			EnvVars: []string{"CF_STATE_FILE"},
+			Usage:   "File to persist the last pushed IP addresses to, so that a restart doesn't query Cloudflare again.",
+		},
+		&cli.StringFlag{
+			Name:    "ip-cache-file",
+			EnvVars: []string{"CF_IP_CACHE_FILE"},
+			Usage:   "File to share the detected IP addresses with other tools, they're read from it until they're older than --ip-cache-ttl.",
+		},
+		&cli.DurationFlag{
+			Name:    "ip-cache-ttl",
+			EnvVars: []string{"CF_IP_CACHE_TTL"},
+			Usage:   "How long the addresses in --ip-cache-file are used before detecting them again (default 5m).",
+		},
+		&cli.BoolFlag{
+			Name:    "ip-cache-readonly",
+			EnvVars: []string{"CF_IP_CACHE_READONLY"},
+			Usage:   "Only read --ip-cache-file, don't write the detected addresses back to it.",
+		},
+		&cli.DurationFlag{
+			Name:    "wait-for-zone",
+			EnvVars: []string{"CF_WAIT_FOR_ZONE"},
+			Usage:   "Keep looking up a zone that isn't found for this long (i.e. 30s), for the zones that were just created. The wait isn't part of the --cycle-timeout.",
+		},
+		&cli.DurationFlag{
+			Name:    "min-change-interval",
+			EnvVars: []string{"CF_MIN_CHANGE_INTERVAL"},
+			Usage:   "Don't change a record again within this long of its last change (i.e. 10m), so that a flapping connection doesn't spam Cloudflare. Use with --state-file to survive restarts.",
+		},
+		&cli.IntFlag{
+			Name:    "retries",
+			Value:   3,
+			EnvVars: []string{"CF_RETRIES"},
+			Usage:   "Maximum number of attempts for the IP provider and Cloudflare requests.",
+		},
+		&cli.DurationFlag{
+			Name:    "retry-delay",
+			Value:   time.Second,
+			EnvVars: []string{"CF_RETRY_DELAY"},
+			Usage:   "Initial delay between the attempts, doubled after every failure.",
+		},
+		&cli.DurationFlag{
+			Name:    "retry-max-delay",
+			Value:   30 * time.Second,
+			EnvVars: []string{"CF_RETRY_MAX_DELAY"},
+			Usage:   "Maximum delay between the attempts.",
+		},
+		&cli.BoolFlag{
+			Name:    "dry-run",
+			EnvVars: []string{"CF_DRY_RUN"},
+			Usage:   "Detect the IP addresses and look the records up, but only log the changes instead of making them.",
+		},
+		&cli.BoolFlag{
+			Name:    "yes",
+			Aliases: []string{"y"},
+			EnvVars: []string{"CF_YES"},
+			Usage:   "Don't ask to confirm the changes when running once in a terminal.",
+		},
+		&cli.BoolFlag{
+			Name:    "ipv4-only",
+			EnvVars: []string{"CF_IPV4_ONLY"},
+			Usage:   "Only update the records of the IP4 address, the auto records become A records.",
+		},
+		&cli.BoolFlag{
+			Name:    "ipv6-only",
+			EnvVars: []string{"CF_IPV6_ONLY"},
+			Usage:   "Only update the records of the IP6 address, the auto records become AAAA records.",
+		},
+		&cli.BoolFlag{
+			Name:    "ipv6-optional",
+			EnvVars: []string{"CF_IPV6_OPTIONAL"},
+			Usage:   "Skip the AAAA records without failing when no global IP6 address can be detected.",
+		},
+		&cli.BoolFlag{
+			Name:    "verify-dns",
+			EnvVars: []string{"CF_VERIFY_DNS"},
+			Usage:   "Skip the Cloudflare API when the record already resolves to the address through 1.1.1.1. Doesn't help proxied records.",
+		},
+		&cli.StringFlag{
+			Name:    "notify-webhook",
+			EnvVars: []string{"CF_NOTIFY_WEBHOOK"},
+			Usage:   "URL to POST a JSON notification to whenever a record is changed.",
+		},
+		&cli.StringFlag{
+			Name:    "pre-hook",
+			EnvVars: []string{"CF_PRE_HOOK"},
+			Usage:   "Shell command run before a record is changed with DDNS_OLD_IP, DDNS_NEW_IP, DDNS_RECORD and DDNS_TYPE set.",
+		},
+		&cli.StringFlag{
+			Name:    "post-hook",
+			EnvVars: []string{"CF_POST_HOOK"},
+			Usage:   "Shell command run after a record was changed with the same environment as --pre-hook.",
+		},
+		&cli.BoolFlag{
+			Name:    "pre-hook-abort",
+			EnvVars: []string{"CF_PRE_HOOK_ABORT"},
+			Usage:   "Don't change the record when the --pre-hook fails.",
+		},
+		&cli.StringFlag{
+			Name:    "metrics-addr",
+			EnvVars: []string{"CF_METRICS_ADDR"},
+			Usage:   "Serve Prometheus metrics on this address (i.e. :9101).",
+		},
+		&cli.StringFlag{
+			Name:    "listen",
+			EnvVars: []string{"CF_LISTEN"},
+			Usage:   "Listen for pushes on this address (i.e. :9000), every POST triggers an update. The addresses in the body or the ip query parameters skip the detection. Polls every --interval, or every hour if not set, as a safety net.",
+		},
+		&cli.StringFlag{
+			Name:    "listen-token",
+			EnvVars: []string{"CF_LISTEN_TOKEN"},
+			Usage:   "Token required from the pushes to --listen, as the bearer token or the token query parameter.",
+		},
+		&cli.StringFlag{
+			Name:    "health-addr",
+			EnvVars: []string{"CF_HEALTH_ADDR"},
+			Usage:   "Serve the /healthz liveness endpoint on this address (i.e. :8080).",
+		},
+		&cli.IntFlag{
+			Name:    "health-max-intervals",
+			Value:   3,
+			EnvVars: []string{"CF_HEALTH_MAX_INTERVALS"},
+			Usage:   "Report unhealthy when no update cycle succeeded within this many intervals.",
+		},
+		&cli.IntFlag{
+			Name:    "concurrency",
+			Value:   4,
+			EnvVars: []string{"CF_CONCURRENCY"},
+			Usage:   "How many records are updated at the same time.",
+		},
+		&cli.Float64Flag{
+			Name:    "rate-limit",
+			Value:   4,
+			EnvVars: []string{"CF_RATE_LIMIT"},
+			Usage:   "Maximum number of Cloudflare API requests per second, the default stays under the 1200 requests per 5 minutes limit.",
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			EnvVars: []string{"CF_QUIET"},
+			Usage:   "Only log the changes and the errors, i.e. to keep the mails of a cron job empty when nothing changed.",
 		},
 		&cli.BoolFlag{
 			Name:  "debug",
-			Usage: "Enables debug logging.",
+			Usage: "Enables debug logging, same as --log-level debug.",
 		},
 		&cli.BoolFlag{
 			Name:  "json",
-			Usage: "Enables JSON output for the logging.",
+			Usage: "Enables JSON output for the logging, same as --log-format json.",
+		},
+		&cli.StringFlag{
+			Name:    "log-format",
+			Value:   "text",
+			EnvVars: []string{"CF_LOG_FORMAT"},
+			Usage:   "Log output format: text or json.",
+		},
+		&cli.BoolFlag{
+			Name:    "no-color",
+			EnvVars: []string{"CF_NO_COLOR", "NO_COLOR"},
+			Usage:   "Disable the colors of the text logs, they are only used on a terminal by default.",
+		},
+		&cli.StringFlag{
+			Name:    "log-output",
+			Value:   "stderr",
+			EnvVars: []string{"CF_LOG_OUTPUT"},
+			Usage:   "Where to write the logs: stderr, stdout or the path of a file to append to.",
+		},
+		&cli.StringFlag{
+			Name:    "log-level",
+			Value:   "info",
+			EnvVars: []string{"CF_LOG_LEVEL"},
+			Usage:   "Log level: trace, debug, info, warn, error, fatal or panic.",
+		},
+	}
+	app.Commands = []*cli.Command{
+		{
+			Name:   "check",
+			Usage:  "Verify the credentials, the zone and the records without changing anything.",
+			Action: Check,
+		},
+		{
+			Name:   "list",
+			Usage:  "Print the DNS records of the zone.",
+			Action: List,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "type",
+					Usage: "Only print the records of this type, i.e. AAAA.",
+				},
+			},
+		},
+		{
+			Name:      "delete",
+			Usage:     "Delete the A and AAAA records of the names that have the --tag or the --comment set.",
+			ArgsUsage: "[name...]",
+			Action:    Delete,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "force",
+					Usage: "Also delete the records without the --tag or the --comment.",
+				},
+			},
+		},
+		{
+			Name:   "reconcile",
+			Usage:  "Make the records of the zones match the config, creating the missing records and correcting the content, the TTL and the proxied state of the existing ones.",
+			Action: Reconcile,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "prune",
+					Usage: "Also delete the records with the --tag or the --comment that aren't in the config.",
+				},
+			},
+		},
+		{
+			Name:  "ip",
+			Usage: "Detect the current IP address with the --source and print it without updating any records.",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "proto",
+					Usage: "Detect the address of this family, 4 or 6. Any family if not set.",
+				},
+			},
+			Action: IP,
+		},
+		{
+			Name:   "version",
+			Usage:  "Print the version, the commit, the build date and the versions of Go and the Cloudflare SDK.",
+			Action: Version,
 		},
 	}
 	app.Before = Before
 	app.Action = Action
 
 	if err := app.Run(os.Args); err != nil {
-		logrus.WithError(err).Fatal()
+		logrus.WithError(err).WithField("event", "failed").Error()
+		os.Exit(ExitCode(err))
 	}
 }
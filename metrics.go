@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// metrics keeps the collectors exposed on the metrics endpoint, they're
+// registered with the default Prometheus registry.
+type metrics struct {
+	updates     *prometheus.CounterVec
+	ipFetch     prometheus.Summary
+	ipHeld      prometheus.Summary
+	lastSuccess prometheus.Gauge
+}
+
+var ddnsMetrics = newMetrics(prometheus.DefaultRegisterer)
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	m := &metrics{
+		updates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ddns_updates_total",
+			Help: "Number of record updates by writer and result.",
+		}, []string{"writer", "result"}),
+		ipFetch: prometheus.NewSummary(prometheus.SummaryOpts{
+			Name: "ddns_ip_fetch_duration_seconds",
+			Help: "Time taken to detect the current IP address.",
+		}),
+		ipHeld: prometheus.NewSummary(prometheus.SummaryOpts{
+			Name: "ddns_ip_held_duration_seconds",
+			Help: "How long the records held the previous address before it changed.",
+		}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ddns_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful update cycle.",
+		}),
+	}
+	// Export the outcomes of the primary writer before the first update.
+	for _, result := range []string{"changed", "nochange", "error"} {
+		m.updates.WithLabelValues(primaryWriter, result)
+	}
+	reg.MustRegister(m.updates, m.ipFetch, m.ipHeld, m.lastSuccess, newCircuitCollector())
+	return m
+}
+
+// recordUpdate counts an update outcome of the writer: changed, nochange,
+// debounced or error.
+func (m *metrics) recordUpdate(writer, result string) {
+	m.updates.WithLabelValues(writer, result).Inc()
+}
+
+func (m *metrics) observeIPFetch(d time.Duration) {
+	m.ipFetch.Observe(d.Seconds())
+}
+
+// observeIPHeld records how long a record held its previous address.
+func (m *metrics) observeIPHeld(d time.Duration) {
+	m.ipHeld.Observe(d.Seconds())
+}
+
+func (m *metrics) recordSuccess(t time.Time) {
+	m.lastSuccess.Set(float64(t.UnixNano()) / 1e9)
+}
+
+// circuitCollector exports the circuits of providerBreaker that are open at
+// the time of the scrape.
+type circuitCollector struct {
+	desc *prometheus.Desc
+}
+
+func newCircuitCollector() circuitCollector {
+	return circuitCollector{desc: prometheus.NewDesc(
+		"ddns_ip_provider_circuit_open",
+		"Whether the IP provider is skipped after repeated failures.",
+		[]string{"endpoint", "proto"}, nil,
+	)}
+}
+
+func (c circuitCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c circuitCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, key := range providerBreaker.open(time.Now()) {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, key.Endpoint, key.Proto.String())
+	}
+}
+
+// serveMetrics exposes the metrics on addr until ctx is done.
+func serveMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		logrus.WithFields(logrus.Fields{
+			"event": "metrics_serving",
+			"addr":  addr,
+		}).Info("serving metrics")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.WithError(err).WithField("event", "metrics_failed").Error("metrics server failed")
+		}
+	}()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestMetrics(t *testing.T) {
+	defer func(b *circuitBreaker) { providerBreaker = b }(providerBreaker)
+	providerBreaker = newCircuitBreaker(1, time.Minute)
+	providerBreaker.failure("https://ip.example.com", RequestProtoIP6, time.Now())
+
+	reg := prometheus.NewRegistry()
+	m := newMetrics(reg)
+	m.recordUpdate("bind", "changed")
+	m.observeIPFetch(time.Second)
+	m.recordSuccess(time.Unix(1700000000, 0))
+
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, want := range []string{
+		`ddns_updates_total{result="nochange",writer="cloudflare"} 0`,
+		`ddns_updates_total{result="changed",writer="bind"} 1`,
+		`ddns_ip_fetch_duration_seconds_count 1`,
+		`ddns_ip_held_duration_seconds_count 0`,
+		`ddns_last_success_timestamp_seconds 1.7e+09`,
+		`ddns_ip_provider_circuit_open{endpoint="https://ip.example.com",proto="ip6"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics are missing %s:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// changeEvent describes a change made to a DNS record.
+type changeEvent struct {
+	Record    string    `json:"record"`
+	Type      string    `json:"type"`
+	OldIP     string    `json:"old_ip"`
+	NewIP     string    `json:"new_ip"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// notifier is told about every change made to a record.
+type notifier interface {
+	Notify(ctx context.Context, event changeEvent) error
+}
+
+// webhookNotifier POSTs the event as JSON to the URL.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	return &webhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, event changeEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "could not encode the event")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not create the webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "webhook request failed")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.Wrap(newHTTPStatusError(res), "webhook request failed")
+	}
+	return nil
+}
+
+// notifyChange sends the event to n, a failure is only logged so that it
+// doesn't fail the DNS update.
+func notifyChange(ctx context.Context, n notifier, event changeEvent) {
+	if n == nil {
+		return
+	}
+	if err := n.Notify(ctx, event); err != nil {
+		logFrom(ctx).WithError(err).WithFields(logrus.Fields{
+			"event": "notify_failed",
+			"name":  event.Record,
+		}).Warn("could not send the change notification")
+	}
+}
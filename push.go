@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// pushPollInterval is the safety-net poll of --listen when --interval isn't
+// set, the pushes trigger the updates in between.
+const pushPollInterval = time.Hour
+
+// maxPushBody limits the body of a push, it only ever holds the addresses.
+const maxPushBody = 1024
+
+// pushedIPs are the addresses a push carried, either may be invalid.
+type pushedIPs struct {
+	IP4, IP6 netip.Addr
+}
+
+// pushListener triggers an update cycle on every POST, i.e. from a router
+// that calls a URL when its WAN address changes. The POST may carry the new
+// addresses, in the ip query parameters or in the body separated by
+// whitespace or commas, to skip the detection.
+type pushListener struct {
+	// Token, when set, has to be sent as the bearer token or the token query
+	// parameter.
+	Token string
+
+	mu      sync.Mutex
+	pending pushedIPs
+	// trigger holds a single pending update, the pushes arriving during a
+	// cycle are coalesced into the next one.
+	trigger chan struct{}
+}
+
+func newPushListener(token string) *pushListener {
+	return &pushListener{Token: token, trigger: make(chan struct{}, 1)}
+}
+
+// take returns and clears the addresses pushed since the last cycle started.
+func (l *pushListener) take() pushedIPs {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ips := l.pending
+	l.pending = pushedIPs{}
+	return ips
+}
+
+func (l *pushListener) authorized(r *http.Request) bool {
+	if l.Token == "" {
+		return true
+	}
+	token := r.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(l.Token)) == 1
+}
+
+// parsePush returns the addresses of the push, at most one of each family.
+func parsePush(r *http.Request) (pushedIPs, error) {
+	fields := r.URL.Query()["ip"]
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPushBody))
+	if err != nil {
+		return pushedIPs{}, errors.Wrap(err, "could not read the body")
+	}
+	s := bufio.NewScanner(strings.NewReader(strings.ReplaceAll(string(body), ",", " ")))
+	s.Split(bufio.ScanWords)
+	for s.Scan() {
+		fields = append(fields, s.Text())
+	}
+
+	var ips pushedIPs
+	for _, field := range fields {
+		ip, err := netip.ParseAddr(strings.TrimSpace(field))
+		if err != nil {
+			return pushedIPs{}, errors.Wrapf(err, "invalid address %q", field)
+		}
+		ip = ip.Unmap()
+		dst := &ips.IP4
+		if ip.Is6() {
+			dst = &ips.IP6
+		}
+		if dst.IsValid() && *dst != ip {
+			return pushedIPs{}, errors.Errorf("more than one address of the family of %v", ip)
+		}
+		*dst = ip
+	}
+	return ips, nil
+}
+
+func (l *pushListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST triggers an update", http.StatusMethodNotAllowed)
+		return
+	}
+	if !l.authorized(r) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	ips, err := parsePush(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	l.mu.Lock()
+	if ips.IP4.IsValid() {
+		l.pending.IP4 = ips.IP4
+	}
+	if ips.IP6.IsValid() {
+		l.pending.IP6 = ips.IP6
+	}
+	l.mu.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"event":  "push_received",
+		"remote": r.RemoteAddr,
+		"ip4":    ips.IP4,
+		"ip6":    ips.IP6,
+	}).Info("received a push, triggering an update")
+	select {
+	case l.trigger <- struct{}{}:
+	default:
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// servePush accepts the pushes on addr until ctx is done.
+func servePush(ctx context.Context, addr string, l *pushListener) {
+	srv := &http.Server{Addr: addr, Handler: l}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		logrus.WithFields(logrus.Fields{
+			"event": "push_serving",
+			"addr":  addr,
+		}).Info("listening for pushes")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.WithError(err).WithField("event", "push_failed").Error("push listener failed")
+		}
+	}()
+}
+
+type pushedKey struct{}
+
+// withPushed returns the context of the cycle triggered by the push of ips.
+func withPushed(ctx context.Context, ips pushedIPs) context.Context {
+	return context.WithValue(ctx, pushedKey{}, ips)
+}
+
+// pushedIPSource returns the address pushed for the cycle, Live detects the
+// addresses that weren't pushed.
+type pushedIPSource struct {
+	Live IPSource
+}
+
+func (s pushedIPSource) GetIP(ctx context.Context, proto RequestProto) (netip.Addr, error) {
+	ips, _ := ctx.Value(pushedKey{}).(pushedIPs)
+	ip := ips.IP4
+	if proto == RequestProtoIP6 || proto == RequestProtoDefault && !ip.IsValid() {
+		ip = ips.IP6
+	}
+	if !ip.IsValid() {
+		return s.Live.GetIP(ctx, proto)
+	}
+	logFrom(ctx).WithFields(logrus.Fields{
+		"event": "ip_pushed",
+		"ip":    ip,
+	}).Debug("using the pushed address")
+	return ip, nil
+}
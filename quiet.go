@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// quietEvents are the info events of the uneventful update cycles that the
+// --quiet mode drops.
+var quietEvents = map[string]bool{
+	"ip_detected":          true,
+	"record_cached":        true,
+	"record_unchanged":     true,
+	"record_unchanged_run": true,
+	"record_debounced":     true,
+	"live_dns_matches":     true,
+	"records_discovered":   true,
+	"cycle_finished":       true,
+}
+
+// quietFormatter drops the info entries of the quietEvents, so that a cron
+// job only prints the changes and the errors. The changes stay at info, so
+// raising the level wouldn't do.
+type quietFormatter struct {
+	logrus.Formatter
+}
+
+func (f quietFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if event, _ := entry.Data["event"].(string); entry.Level >= logrus.InfoLevel && quietEvents[event] {
+		return nil, nil
+	}
+	return f.Formatter.Format(entry)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// Reconcile will make the records of the zones match the config: the missing
+// records are created and the content, the TTL and the proxied state of the
+// existing ones are corrected. Unlike the update, it always compares with
+// Cloudflare instead of the last known addresses. With --prune the records
+// that are managed by the tool but aren't in the config are deleted.
+func Reconcile(c *cli.Context) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	cfg, api, err := setup(c)
+	if err != nil {
+		return err
+	}
+	if c.Bool("prune") && len(cfg.Tags) == 0 && cfg.Comment == "" {
+		return cli.Exit("--prune needs the --tag or the --comment that mark the managed records", ExitConfig)
+	}
+
+	providers, err := cfg.sourceProviders()
+	if err != nil {
+		return cli.Exit(err.Error(), ExitConfig)
+	}
+
+	opts := updateOptions{
+		CreateIfMissing: true,
+		TTL:             cfg.TTL,
+		Proxied:         cfg.Proxied,
+		DryRun:          c.Bool("dry-run"),
+		Concurrency:     c.Int("concurrency"),
+		AllowPrivate:    c.Bool("allow-private"),
+		IPv6Optional:    c.Bool("ipv6-optional"),
+		Comment:         cfg.Comment,
+		Tags:            cfg.Tags,
+		Summary:         &updateSummary{},
+	}
+	if !c.Bool("yes") && !c.Bool("dry-run") {
+		opts.Confirm = newTTYConfirmer(c.App.ErrWriter)
+	}
+	opts.AllowedCIDRs, _ = parsePrefixes(cfg.AllowedCIDRs)
+	opts.DeniedCIDRs, _ = parsePrefixes(cfg.DeniedCIDRs)
+	if opts.Writers, err = cfg.writers(cloudflare.UsingRateLimit(c.Float64("rate-limit"))); err != nil {
+		return cli.Exit(err.Error(), ExitConfig)
+	}
+
+	zones := newZoneResolver(api)
+	zones.wait = c.Duration("wait-for-zone")
+	ctx = withIPMemo(ctx)
+
+	var errs []error
+	for _, zone := range cfg.zoneSpecs() {
+		if err := updateZone(ctx, api, zones, zone, cfg.Source, providers, opts); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", zone.name(), err))
+			continue
+		}
+		if !c.Bool("prune") {
+			continue
+		}
+		if err := pruneZone(ctx, api, zones, zone, cfg, opts); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", zone.name(), err))
+		}
+	}
+	fmt.Fprintln(c.App.Writer, opts.Summary)
+	return stderrors.Join(errs...)
+}
+
+// pruneZone deletes the records of the zone that are managed by the tool, but
+// aren't configured. The writers delete the records the primary one deleted.
+func pruneZone(ctx context.Context, api dnsAPI, zones *zoneResolver, zone ZoneSpec, cfg *Config, opts updateOptions) error {
+	zoneID := zone.ZoneID
+	if zoneID == "" {
+		var err error
+		if zoneID, err = zones.Resolve(ctx, zone.Zone); err != nil {
+			return err
+		}
+	}
+
+	// The names are compared the way Cloudflare does, regardless of the case
+	// and of the trailing dot.
+	configured := map[string]bool{}
+	for _, r := range zone.Records {
+		name := normalizeRecordName(r.Name)
+		switch r.Type {
+		case "auto":
+			configured[recordKey("A", name)] = true
+			configured[recordKey("AAAA", name)] = true
+		default:
+			configured[recordKey(r.Type, name)] = true
+		}
+	}
+
+	primary := cloudflareWriter{name: primaryWriter, api: api, zoneID: zoneID}
+	opts.Writer = primary.Name()
+	ctx = withLog(ctx, logFrom(ctx).WithField("zone", zone.name()))
+	deleted, err := primary.PruneRecords(ctx, zone.Zone, func(record cloudflare.DNSRecord) bool {
+		return !configured[recordKey(record.Type, normalizeRecordName(record.Name))] && isManaged(record, cfg)
+	}, opts)
+	errs := []error{err}
+	if len(deleted) == 0 {
+		return err
+	}
+	for _, w := range opts.Writers {
+		ctx := withLog(ctx, logFrom(ctx).WithField("writer", w.Name()))
+		_, err := w.PruneRecords(ctx, zone.Zone, func(record cloudflare.DNSRecord) bool {
+			return deleted[recordKey(record.Type, record.Name)] && isManaged(record, cfg)
+		}, w.options(opts))
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "writer %s", w.Name()))
+		}
+	}
+	return stderrors.Join(errs...)
+}
+
+// pruneRecords deletes the records of the zone that prune selects and returns
+// the recordKey of the deleted ones.
+func pruneRecords(ctx context.Context, api dnsAPI, zoneID string, prune func(cloudflare.DNSRecord) bool, opts updateOptions) (map[string]bool, error) {
+	var records []cloudflare.DNSRecord
+	err := withRetry(ctx, defaultRetryPolicy, func() error {
+		var err error
+		records, _, err = api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{})
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing dns records for zone")
+	}
+
+	deleted := map[string]bool{}
+	var errs []error
+	for _, record := range records {
+		if !prune(record) {
+			continue
+		}
+		log := logFrom(ctx).WithFields(logrus.Fields{
+			"name":    record.Name,
+			"type":    record.Type,
+			"content": record.Content,
+		})
+		if opts.DryRun {
+			log.WithField("event", "record_would_prune").Infof("would delete %s, it's not in the config", record.Name)
+			continue
+		}
+		if !opts.Confirm.confirm(changeEvent{Record: record.Name, Type: record.Type, OldIP: record.Content, NewIP: "(deleted)"}) {
+			log.WithField("event", "record_change_declined").Info("not deleting the record")
+			continue
+		}
+		err := withRetry(ctx, defaultRetryPolicy, func() error {
+			return api.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), record.ID)
+		})
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "could not delete %s record %s", record.Type, record.Name))
+			continue
+		}
+		log.WithField("event", "record_pruned").Info("deleted the record that's not in the config")
+		deleted[recordKey(record.Type, record.Name)] = true
+		opts.Summary.add(true, nil)
+	}
+	return deleted, stderrors.Join(errs...)
+}
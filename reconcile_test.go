@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+)
+
+func TestPruneZone(t *testing.T) {
+	managed := func(id, name string) cloudflare.DNSRecord {
+		return cloudflare.DNSRecord{ID: id, Name: name, Type: "A", Content: "203.0.113.7", Tags: []string{"ddns"}}
+	}
+	api := &fakeDNS{records: []cloudflare.DNSRecord{
+		managed("kept", "home.example.com"),
+		managed("stale", "old.example.com"),
+		{ID: "other", Name: "www.example.com", Type: "A", Content: "203.0.113.7"},
+	}}
+	zone := ZoneSpec{ZoneID: "zone", Records: []RecordSpec{{Name: "Home.Example.com.", Type: "A"}}}
+	if err := pruneZone(context.Background(), api, nil, zone, &Config{Tags: []string{"ddns"}}, updateOptions{}); err != nil {
+		t.Fatalf("pruneZone() error = %v", err)
+	}
+	if !slices.Equal(api.deletes, []string{"stale"}) {
+		t.Errorf("pruneZone() deleted %v, want only the stale record", api.deletes)
+	}
+}
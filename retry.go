@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type retryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// defaultRetryPolicy is used by the update functions, it is configured from the
+// command line flags.
+var defaultRetryPolicy = retryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+}
+
+// rateLimitDelay is the pause after the Cloudflare API rate limited the
+// requests, the SDK doesn't expose the Retry-After header.
+const rateLimitDelay = time.Minute
+
+// httpStatusError is returned when a HTTP endpoint responds with a non 2xx status.
+type httpStatusError struct {
+	StatusCode int
+	// RetryAfter is the delay requested by the Retry-After header.
+	RetryAfter time.Duration
+}
+
+func newHTTPStatusError(res *http.Response) *httpStatusError {
+	err := &httpStatusError{StatusCode: res.StatusCode}
+	if secs, parseErr := strconv.Atoi(res.Header.Get("Retry-After")); parseErr == nil && secs > 0 {
+		err.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return err
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected http status %d", e.StatusCode)
+}
+
+// delay returns the backoff before the given retry attempt (starting at 1)
+// with up to 50% of random jitter applied.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// isRateLimited reports whether err is caused by too many requests and the
+// delay to wait before trying again.
+func isRateLimited(err error) (time.Duration, bool) {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests {
+		return statusErr.RetryAfter, true
+	}
+
+	var rateLimitErr *cloudflare.RatelimitError
+	if errors.As(err, &rateLimitErr) {
+		return rateLimitDelay, true
+	}
+	// The SDK retries the 429 responses itself and then gives up with an untyped error.
+	if err != nil && strings.Contains(err.Error(), "exceeded available rate limit retries") {
+		return rateLimitDelay, true
+	}
+	return 0, false
+}
+
+// isRetryable reports whether err is a transient network or server side failure.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	// Trying again won't unlock the record.
+	if isRecordLocked(err) {
+		return false
+	}
+
+	if _, ok := isRateLimited(err); ok {
+		return true
+	}
+
+	var serviceErr *cloudflare.ServiceError
+	if errors.As(err, &serviceErr) {
+		return true
+	}
+
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// withRetry calls fn until it succeeds, returns a non retryable error or the
+// attempts of the policy are exhausted.
+func withRetry(ctx context.Context, policy retryPolicy, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= policy.MaxAttempts || !isRetryable(err) {
+			return err
+		}
+
+		delay := policy.delay(attempt)
+		if retryAfter, ok := isRateLimited(err); ok && retryAfter > delay {
+			delay = retryAfter
+		}
+		logFrom(ctx).WithError(err).WithFields(logrus.Fields{
+			"event":   "retry",
+			"attempt": attempt,
+			"delay":   delay,
+		}).Warn("retrying")
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return errors.Wrap(ctx.Err(), err.Error())
+		case <-t.C:
+		}
+	}
+}
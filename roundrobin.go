@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	stderrors "errors"
+	"slices"
+	"strings"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// updateRoundRobin points one record of the name at the address detected by
+// each of the sources of the spec, i.e. of the two WAN links. The sources
+// whose address can't be detected drop out of the rotation until it's back.
+func updateRoundRobin(ctx context.Context, api dnsAPI, zoneID string, spec RecordSpec, providers map[string]sourceProviders, opts updateOptions) (UpdateResult, error) {
+	// The sources were validated with the config, only A and AAAA records
+	// can have them.
+	proto, _ := protoForRecordType(spec.Type)
+
+	var contents []string
+	var errs []error
+	for _, source := range spec.Sources {
+		p := providers[source].IP4
+		if proto == RequestProtoIP6 {
+			p = providers[source].IP6
+		}
+		ip, err := p.currentIP(ctx, proto)
+		if err == nil {
+			err = checkAddr(ctx, ip, opts)
+		}
+		if err != nil {
+			logFrom(ctx).WithError(err).WithFields(logrus.Fields{
+				"event":  "round_robin_source_failed",
+				"source": source,
+			}).Warn("could not get the address of the source, dropping it from the rotation")
+			errs = append(errs, withExitCode(errors.Wrapf(err, "source %s", source), ExitNetwork))
+			continue
+		}
+		if content := ip.String(); !slices.Contains(contents, content) {
+			contents = append(contents, content)
+		}
+	}
+	if len(contents) == 0 {
+		return UpdateResult{}, errors.Wrapf(stderrors.Join(errs...), "no address of %s record %s could be detected", spec.Type, spec.Name)
+	}
+
+	primary := cloudflareWriter{name: primaryWriter, api: api, zoneID: zoneID}
+	result, err := fanOut(ctx, primary, spec.options(opts), func(ctx context.Context, w RecordWriter, opts updateOptions) (UpdateResult, error) {
+		return w.ReconcileRecords(ctx, opts.ZoneName, spec.Name, spec.Type, contents, opts)
+	})
+	return result, stderrors.Join(append(errs, err)...)
+}
+
+// reconcileRecords makes sure that there is exactly one record of the name
+// and type with each of the contents. The stale records are updated to the
+// missing contents first, then the rest of them is created or deleted.
+func reconcileRecords(ctx context.Context, api dnsAPI, zoneID, domainName, recordType string, contents []string, opts updateOptions) (UpdateResult, error) {
+	// The cache only holds a single content per record.
+	opts.Cache = nil
+
+	var records []cloudflare.DNSRecord
+	err := withRetry(ctx, defaultRetryPolicy, func() error {
+		var err error
+		records, _, err = api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{
+			Name: domainName,
+			Type: recordType,
+		})
+		return err
+	})
+	if err != nil {
+		return UpdateResult{}, errors.Wrap(err, "error listing dns records for zone")
+	}
+
+	var result UpdateResult
+	missing := slices.Clone(contents)
+	var stale []cloudflare.DNSRecord
+	for _, record := range records {
+		i := slices.IndexFunc(missing, func(content string) bool {
+			return sameContent(recordType, record.Content, content)
+		})
+		if i < 0 {
+			stale = append(stale, record)
+			continue
+		}
+		missing = slices.Delete(missing, i, i+1)
+		// The TTL and the proxied state of the kept records may still change.
+		outcome, err := applyContent(ctx, api, zoneID, record, record.Content, opts)
+		if err != nil {
+			return UpdateResult{}, errors.Wrapf(err, "record %s", record.ID)
+		}
+		result.Changed = result.Changed || outcome == applyChanged
+		result.Declined = result.Declined || outcome == applyDeclined
+	}
+
+	var errs []error
+	for _, content := range missing {
+		if len(stale) > 0 {
+			record := stale[0]
+			stale = stale[1:]
+			outcome, err := applyContent(ctx, api, zoneID, record, content, opts)
+			if err != nil {
+				errs = append(errs, errors.Wrapf(err, "record %s", record.ID))
+			}
+			result.Changed = result.Changed || outcome == applyChanged
+			result.Declined = result.Declined || outcome == applyDeclined
+			continue
+		}
+		created, err := createRecord(ctx, api, zoneID, domainName, recordType, content, opts)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		result.Changed = result.Changed || created.Changed
+		result.Declined = result.Declined || created.Declined
+	}
+
+	for _, record := range stale {
+		log := logFrom(ctx).WithFields(logrus.Fields{
+			"name":    record.Name,
+			"type":    record.Type,
+			"content": record.Content,
+		})
+		if opts.DryRun {
+			log.WithField("event", "record_would_delete").Infof("would delete the stale record %s with %s", record.Name, record.Content)
+			continue
+		}
+		if !opts.Confirm.confirm(changeEvent{Record: record.Name, Type: record.Type, OldIP: record.Content, NewIP: "(deleted)"}) {
+			log.WithField("event", "record_change_declined").Info("not deleting the record")
+			result.Declined = true
+			continue
+		}
+		err := withRetry(ctx, defaultRetryPolicy, func() error {
+			return api.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), record.ID)
+		})
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "could not delete the stale record %s", record.ID))
+			continue
+		}
+		log.WithField("event", "record_deleted").Info("deleted the stale record")
+		ddnsMetrics.recordUpdate(opts.writer(), "changed")
+		result.Changed = true
+	}
+
+	result.NewContent = strings.Join(contents, ",")
+	return result, stderrors.Join(errs...)
+}
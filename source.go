@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598).
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicAddr reports whether ip is a global unicast address that's reachable
+// from the internet.
+func isPublicAddr(ip netip.Addr) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// withIPv6Suffix keeps the first prefixLen bits of ip and replaces the rest
+// with the host bits of suffix, i.e. 2001:db8:1:2:aaaa:bbbb:cccc:dddd with
+// the ::1234 suffix and /64 becomes 2001:db8:1:2::1234.
+func withIPv6Suffix(ip netip.Addr, prefixLen int, suffix netip.Addr) (netip.Addr, error) {
+	if !ip.Is6() || !suffix.Is6() {
+		return netip.Addr{}, errors.Errorf("ipv6 suffix %v needs an ipv6 address, got %v", suffix, ip)
+	}
+	prefix, err := ip.Prefix(prefixLen)
+	if err != nil {
+		return netip.Addr{}, errors.Wrap(err, "invalid ipv6 prefix length")
+	}
+
+	addr := prefix.Masked().Addr().As16()
+	host := suffix.As16()
+	for i := range addr {
+		// The bits of this byte that are not part of the prefix.
+		bits := prefixLen - i*8
+		var mask byte = 0xff
+		switch {
+		case bits >= 8:
+			mask = 0
+		case bits > 0:
+			mask = 0xff >> bits
+		}
+		addr[i] |= host[i] & mask
+	}
+	return netip.AddrFrom16(addr), nil
+}
+
+// getInterfaceIP returns the first public address of the requested family that's
+// assigned to the network interface.
+func getInterfaceIP(ifaceName string, proto RequestProto) (netip.Addr, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return netip.Addr{}, errors.Wrap(err, "could not find the network interface")
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return netip.Addr{}, errors.Wrapf(err, "could not list the addresses of %s", ifaceName)
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok {
+			continue
+		}
+		ip = ip.Unmap()
+		if proto == RequestProtoIP4 && !ip.Is4() || proto == RequestProtoIP6 && !ip.Is6() {
+			continue
+		}
+		if isPublicAddr(ip) {
+			return ip, nil
+		}
+	}
+	return netip.Addr{}, errors.Errorf("no public address found on %s", ifaceName)
+}
+
+// IPSource detects the current address of the given family.
+type IPSource interface {
+	GetIP(ctx context.Context, proto RequestProto) (netip.Addr, error)
+}
+
+// httpIPSource asks the ip address service endpoints for the address.
+type httpIPSource struct {
+	Endpoints []ProviderSpec
+	// Strict requires two different endpoints to return the same address.
+	Strict bool
+	// Bind selects the local interface or address of the requests to the endpoints.
+	Bind bindOptions
+	// Transport replaces the family and Bind aware transport of the requests,
+	// i.e. to serve canned responses.
+	Transport http.RoundTripper
+	// UserAgent of the requests to the endpoints.
+	UserAgent string
+}
+
+func (s httpIPSource) GetIP(ctx context.Context, proto RequestProto) (netip.Addr, error) {
+	client := newIPProviderClient(proto, s.Bind, s.Transport, s.UserAgent)
+
+	var ip netip.Addr
+	err := withRetry(ctx, defaultRetryPolicy, func() error {
+		var err error
+		if s.Strict {
+			ip, err = getCurrentIPConsensus(ctx, client, s.Endpoints, proto)
+		} else {
+			ip, err = getCurrentIPWithFallback(ctx, client, s.Endpoints, proto)
+		}
+		return err
+	})
+	return ip, err
+}
+
+// interfaceIPSource reads the address from a local network interface.
+type interfaceIPSource struct {
+	Name string
+}
+
+func (s interfaceIPSource) GetIP(_ context.Context, proto RequestProto) (netip.Addr, error) {
+	return getInterfaceIP(s.Name, proto)
+}
+
+// dnsIPSource asks public resolvers for the address.
+type dnsIPSource struct{}
+
+func (dnsIPSource) GetIP(ctx context.Context, proto RequestProto) (netip.Addr, error) {
+	var ip netip.Addr
+	err := withRetry(ctx, defaultRetryPolicy, func() error {
+		var err error
+		ip, err = getCurrentIPviaDNS(ctx, proto)
+		return err
+	})
+	return ip, err
+}
+
+// stunIPSource discovers the address with a STUN Binding Request.
+type stunIPSource struct {
+	Server string
+}
+
+func (s stunIPSource) GetIP(ctx context.Context, proto RequestProto) (netip.Addr, error) {
+	var ip netip.Addr
+	err := withRetry(ctx, defaultRetryPolicy, func() error {
+		var err error
+		ip, err = getCurrentIPviaSTUN(ctx, s.Server, proto)
+		return err
+	})
+	return ip, err
+}
+
+// upnpIPSource asks the UPnP Internet Gateway Device for its WAN address.
+type upnpIPSource struct{}
+
+func (upnpIPSource) GetIP(ctx context.Context, proto RequestProto) (netip.Addr, error) {
+	if proto == RequestProtoIP6 {
+		return netip.Addr{}, errors.New("the upnp source only supports ipv4")
+	}
+	var ip netip.Addr
+	err := withRetry(ctx, defaultRetryPolicy, func() error {
+		var err error
+		ip, err = getCurrentIPviaUPnP(ctx)
+		return err
+	})
+	return ip, err
+}
+
+// staticIPSource returns a fixed address instead of detecting one, i.e. to pin
+// the records to a backup host.
+type staticIPSource struct {
+	IP netip.Addr
+}
+
+func (s staticIPSource) GetIP(_ context.Context, proto RequestProto) (netip.Addr, error) {
+	if proto == RequestProtoIP4 && !s.IP.Is4() || proto == RequestProtoIP6 && !s.IP.Is6() {
+		return netip.Addr{}, errors.Errorf("the forced address %v is of the other family", s.IP)
+	}
+	return s.IP, nil
+}
+
+// fallbackIPSource returns the fallback address of the family when Live can't
+// detect it, so that the records point at a backup instead of going stale.
+type fallbackIPSource struct {
+	Live     IPSource
+	IP4, IP6 netip.Addr
+}
+
+func (s fallbackIPSource) GetIP(ctx context.Context, proto RequestProto) (netip.Addr, error) {
+	ip, err := s.Live.GetIP(ctx, proto)
+	if err == nil || ctx.Err() != nil {
+		return ip, err
+	}
+
+	fallback := s.IP4
+	if proto == RequestProtoIP6 || proto == RequestProtoDefault && !fallback.IsValid() {
+		fallback = s.IP6
+	}
+	if !fallback.IsValid() {
+		return ip, err
+	}
+	logFrom(ctx).WithError(err).WithFields(logrus.Fields{
+		"event":    "ip_fallback",
+		"fallback": fallback,
+	}).Warn("could not detect the current IP address, using the fallback address")
+	return fallback, nil
+}
+
+// newIPSource returns the IP source for the selector. The supported selectors
+// are "http" (the endpoints of base), an "http://" or "https://" URL of a
+// single endpoint, "interface:<name>", "dns" (the OpenDNS/Google resolvers),
+// "stun[:host:port]" and "upnp" (the gateway of the local network).
+func newIPSource(selector string, base httpIPSource) (IPSource, error) {
+	if strings.HasPrefix(selector, "http://") || strings.HasPrefix(selector, "https://") {
+		base.Endpoints = []ProviderSpec{{URL: selector}}
+		return base, nil
+	}
+
+	kind, arg, _ := strings.Cut(selector, ":")
+	switch kind {
+	case "", "http":
+		return base, nil
+	case "interface":
+		if arg == "" {
+			return nil, errors.New("missing the interface name in the ip source, i.e. interface:eth0")
+		}
+		return interfaceIPSource{Name: arg}, nil
+	case "dns":
+		return dnsIPSource{}, nil
+	case "upnp":
+		return upnpIPSource{}, nil
+	case "stun":
+		if arg == "" {
+			arg = stunDefaultServer
+		}
+		return stunIPSource{Server: arg}, nil
+	}
+	return nil, errors.Errorf("unknown ip source %q", selector)
+}
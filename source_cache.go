@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// cachedIP is an address in the shared IP cache file.
+type cachedIP struct {
+	IP        netip.Addr `json:"ip"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// ipCacheMu serializes the updates of the cache files by the sources of the
+// different families.
+var ipCacheMu sync.Mutex
+
+// cachedFileSource reads the address from a file shared with other tools, so
+// that one of them polls the providers for all of them. When the cached
+// address is missing or older than TTL it's detected with Live and written
+// back to the file unless ReadOnly is set. The addresses are kept by the
+// Selector of the source, the different sources of the records and of the
+// round robin records detect different addresses.
+type cachedFileSource struct {
+	Path     string
+	Selector string
+	TTL      time.Duration
+	ReadOnly bool
+	Live     IPSource
+}
+
+// cacheKey returns the key of the address of the family detected with the
+// source selector in the cache file. The default providers keep the plain
+// family keys the other tools read.
+func cacheKey(selector string, proto RequestProto) string {
+	family := proto.String()
+	if selector == "" || selector == "http" {
+		return family
+	}
+	return selector + " " + family
+}
+
+func (s cachedFileSource) GetIP(ctx context.Context, proto RequestProto) (netip.Addr, error) {
+	key := cacheKey(s.Selector, proto)
+	entries, err := readIPCache(s.Path)
+	if err != nil {
+		// A broken cache shouldn't stop the updates, it's rewritten below.
+		logFrom(ctx).WithError(err).WithFields(logrus.Fields{
+			"event": "ip_cache_invalid",
+			"path":  s.Path,
+		}).Warn("could not read the ip cache")
+	}
+	if entry, ok := entries[key]; ok && entry.IP.IsValid() && time.Since(entry.UpdatedAt) < s.TTL {
+		logFrom(ctx).WithFields(logrus.Fields{
+			"event": "ip_cache_hit",
+			"path":  s.Path,
+			"ip":    entry.IP,
+			"age":   time.Since(entry.UpdatedAt).Round(time.Second),
+		}).Debug("using the cached IP address")
+		return entry.IP, nil
+	}
+
+	ip, err := s.Live.GetIP(ctx, proto)
+	if err != nil || s.ReadOnly {
+		return ip, err
+	}
+	if err := writeIPCache(s.Path, key, cachedIP{IP: ip, UpdatedAt: time.Now()}); err != nil {
+		logFrom(ctx).WithError(err).WithFields(logrus.Fields{
+			"event": "ip_cache_write_failed",
+			"path":  s.Path,
+		}).Warn("could not update the ip cache")
+	}
+	return ip, nil
+}
+
+// readIPCache reads the cached addresses keyed by family, a missing file is
+// an empty cache.
+func readIPCache(path string) (map[string]cachedIP, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read the ip cache file")
+	}
+	var entries map[string]cachedIP
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, errors.Wrap(err, "could not parse the ip cache file")
+	}
+	return entries, nil
+}
+
+// writeIPCache stores the address of the family in the cache file, keeping
+// the addresses of the other families. Like the state file it's replaced
+// atomically so the readers never see a partial file.
+func writeIPCache(path, key string, entry cachedIP) error {
+	ipCacheMu.Lock()
+	defer ipCacheMu.Unlock()
+
+	entries, _ := readIPCache(path)
+	if entries == nil {
+		entries = make(map[string]cachedIP)
+	}
+	entries[key] = entry
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not encode the ip cache")
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "could not create the ip cache file")
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return errors.Wrap(err, "could not write the ip cache file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "could not write the ip cache file")
+	}
+	// CreateTemp makes the file private, the other tools need to read it.
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return errors.Wrap(err, "could not write the ip cache file")
+	}
+	return errors.Wrap(os.Rename(f.Name(), path), "could not replace the ip cache file")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// staticSource is an IPSource of a fixed address.
+type staticSource netip.Addr
+
+func (s staticSource) GetIP(ctx context.Context, proto RequestProto) (netip.Addr, error) {
+	return netip.Addr(s), nil
+}
+
+func TestCachedFileSourceSelectors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip.json")
+	sources := map[string]netip.Addr{
+		"":               netip.MustParseAddr("203.0.113.1"),
+		"interface:wan1": netip.MustParseAddr("203.0.113.2"),
+		"interface:wan2": netip.MustParseAddr("203.0.113.3"),
+	}
+	// Both rounds read the addresses, the second one from the cache.
+	for round := 0; round < 2; round++ {
+		for selector, want := range sources {
+			live := staticSource(want)
+			if round > 0 {
+				live = staticSource(netip.IPv4Unspecified())
+			}
+			s := cachedFileSource{Path: path, Selector: selector, TTL: time.Hour, Live: live}
+			got, err := s.GetIP(context.Background(), RequestProtoIP4)
+			if err != nil {
+				t.Fatalf("GetIP(%q) error = %v", selector, err)
+			}
+			if got != want {
+				t.Errorf("round %d: GetIP(%q) = %v, want %v", round, selector, got, want)
+			}
+		}
+	}
+
+	entries, err := readIPCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries["ip4"].IP != sources[""] {
+		t.Errorf("the default source is cached as %v under ip4, want %v", entries["ip4"].IP, sources[""])
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// dnsIPService is a resolver that answers a special name with the address the
+// query came from.
+type dnsIPService struct {
+	name string
+	// txt is set when the address is returned in a TXT record instead of A/AAAA.
+	txt       bool
+	resolver4 string
+	resolver6 string
+}
+
+var dnsIPServices = []dnsIPService{
+	{
+		name:      "myip.opendns.com.",
+		resolver4: "208.67.222.222:53",
+		resolver6: "[2620:119:35::35]:53",
+	},
+	{
+		name:      "o-o.myaddr.l.google.com.",
+		txt:       true,
+		resolver4: "216.239.32.10:53",
+		resolver6: "[2001:4860:4802:32::a]:53",
+	},
+}
+
+func (s dnsIPService) lookup(ctx context.Context, proto RequestProto) (netip.Addr, error) {
+	network, server := "udp4", s.resolver4
+	if proto == RequestProtoIP6 {
+		network, server = "udp6", s.resolver6
+	}
+
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+
+	var values []string
+	if s.txt {
+		txt, err := r.LookupTXT(ctx, s.name)
+		if err != nil {
+			return netip.Addr{}, errors.Wrap(err, "dns lookup failed")
+		}
+		values = txt
+	} else {
+		family := "ip4"
+		if proto == RequestProtoIP6 {
+			family = "ip6"
+		}
+		ips, err := r.LookupNetIP(ctx, family, s.name)
+		if err != nil {
+			return netip.Addr{}, errors.Wrap(err, "dns lookup failed")
+		}
+		for _, ip := range ips {
+			values = append(values, ip.String())
+		}
+	}
+
+	for _, v := range values {
+		ip, err := netip.ParseAddr(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		ip = ip.Unmap()
+		if proto == RequestProtoIP6 && ip.Is6() || proto != RequestProtoIP6 && ip.Is4() {
+			return ip, nil
+		}
+	}
+	return netip.Addr{}, errors.Errorf("no address in the dns answer %v", values)
+}
+
+// resolveCurrent looks up the address name currently resolves to through
+// the Cloudflare public resolver, bypassing any local resolver cache.
+func resolveCurrent(ctx context.Context, name string, proto RequestProto) (netip.Addr, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, "1.1.1.1:53")
+		},
+	}
+
+	family := "ip4"
+	if proto == RequestProtoIP6 {
+		family = "ip6"
+	}
+	ips, err := r.LookupNetIP(ctx, family, name)
+	if err != nil {
+		return netip.Addr{}, errors.Wrapf(err, "could not resolve %s", name)
+	}
+	return ips[0].Unmap(), nil
+}
+
+// getCurrentIPviaDNS asks the OpenDNS and then the Google resolvers for the
+// address the query was sent from.
+func getCurrentIPviaDNS(ctx context.Context, proto RequestProto) (netip.Addr, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var errs []string
+	for _, s := range dnsIPServices {
+		ip, err := s.lookup(ctx, proto)
+		if err == nil {
+			return ip, nil
+		}
+		logFrom(ctx).WithError(err).WithFields(logrus.Fields{
+			"event": "dns_lookup_failed",
+			"name":  s.name,
+		}).Warn("dns ip lookup failed")
+		errs = append(errs, s.name+": "+err.Error())
+	}
+	return netip.Addr{}, errors.Errorf("all dns ip lookups failed: %s", strings.Join(errs, "; "))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+)
+
+// ipMemo holds the addresses detected during one update cycle.
+type ipMemo struct {
+	mu      sync.Mutex
+	entries map[string]*ipMemoEntry
+}
+
+type ipMemoEntry struct {
+	once sync.Once
+	ip   netip.Addr
+	err  error
+}
+
+type ipMemoKey struct{}
+
+// withIPMemo returns the context of an update cycle in which every source is
+// asked for the address of a family at most once, so that all of the records
+// of the cycle get the same address even if it changes in the meantime.
+func withIPMemo(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ipMemoKey{}, &ipMemo{entries: map[string]*ipMemoEntry{}})
+}
+
+// memoIPSource remembers the address Live returns for the cycle of the
+// context. The contexts without a cycle always ask Live.
+type memoIPSource struct {
+	// Key identifies the source within the cycle, i.e. its selector.
+	Key  string
+	Live IPSource
+}
+
+func (s memoIPSource) GetIP(ctx context.Context, proto RequestProto) (netip.Addr, error) {
+	memo, ok := ctx.Value(ipMemoKey{}).(*ipMemo)
+	if !ok {
+		return s.Live.GetIP(ctx, proto)
+	}
+
+	key := fmt.Sprintf("%s/%d", s.Key, proto)
+	memo.mu.Lock()
+	entry, ok := memo.entries[key]
+	if !ok {
+		entry = &ipMemoEntry{}
+		memo.entries[key] = entry
+	}
+	memo.mu.Unlock()
+
+	// The concurrent callers wait for the first one.
+	entry.once.Do(func() {
+		entry.ip, entry.err = s.Live.GetIP(ctx, proto)
+	})
+	return entry.ip, entry.err
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	stunMagicCookie       = 0x2112A442
+	stunBindingRequest    = 0x0001
+	stunBindingSuccess    = 0x0101
+	stunAttrMappedAddress = 0x0001
+	stunAttrXORMappedAddr = 0x0020
+	stunHeaderSize        = 20
+	stunFamilyIP4         = 0x01
+	stunFamilyIP6         = 0x02
+	stunDefaultServer     = "stun.cloudflare.com:3478"
+	stunMaxResponseSize   = 1500
+	stunRoundTripTimeout  = 5 * time.Second
+)
+
+// getCurrentIPviaSTUN sends a STUN Binding Request (RFC 5389) to the server and
+// returns the mapped address from the response.
+func getCurrentIPviaSTUN(ctx context.Context, stunServer string, proto RequestProto) (netip.Addr, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, networkForProto("udp", proto), stunServer)
+	if err != nil {
+		return netip.Addr{}, errors.Wrap(err, "could not connect to the stun server")
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(stunRoundTripTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return netip.Addr{}, errors.Wrap(err, "could not set the stun deadline")
+	}
+
+	req := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(req[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:8], stunMagicCookie)
+	if _, err := rand.Read(req[8:20]); err != nil {
+		return netip.Addr{}, errors.Wrap(err, "could not generate the stun transaction id")
+	}
+	if _, err := conn.Write(req); err != nil {
+		return netip.Addr{}, errors.Wrap(err, "stun request failed")
+	}
+
+	res := make([]byte, stunMaxResponseSize)
+	n, err := conn.Read(res)
+	if err != nil {
+		return netip.Addr{}, errors.Wrap(err, "stun request failed")
+	}
+
+	ip, err := parseSTUNResponse(res[:n], req[8:20])
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if proto == RequestProtoIP4 && !ip.Is4() || proto == RequestProtoIP6 && !ip.Is6() {
+		return netip.Addr{}, errors.Errorf("ip addr family mismatch %v", ip)
+	}
+	return ip, nil
+}
+
+// parseSTUNResponse extracts the (XOR-)MAPPED-ADDRESS from a Binding Success response.
+func parseSTUNResponse(msg, txID []byte) (netip.Addr, error) {
+	if len(msg) < stunHeaderSize {
+		return netip.Addr{}, errors.New("stun response too short")
+	}
+	if binary.BigEndian.Uint16(msg[0:2]) != stunBindingSuccess {
+		return netip.Addr{}, errors.Errorf("unexpected stun message type %#04x", binary.BigEndian.Uint16(msg[0:2]))
+	}
+	if binary.BigEndian.Uint32(msg[4:8]) != stunMagicCookie || !bytes.Equal(msg[8:20], txID) {
+		return netip.Addr{}, errors.New("stun response does not match the request")
+	}
+
+	length := int(binary.BigEndian.Uint16(msg[2:4]))
+	attrs := msg[stunHeaderSize:]
+	if length < len(attrs) {
+		attrs = attrs[:length]
+	}
+
+	var mapped netip.Addr
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:2])
+		size := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+size > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+size]
+
+		switch typ {
+		case stunAttrXORMappedAddr:
+			if ip, ok := parseSTUNAddress(value, msg[4:20]); ok {
+				return ip, nil
+			}
+		case stunAttrMappedAddress:
+			if ip, ok := parseSTUNAddress(value, nil); ok {
+				mapped = ip
+			}
+		}
+
+		// Attributes are padded to a multiple of 4 bytes.
+		next := 4 + (size+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+
+	if mapped.IsValid() {
+		return mapped, nil
+	}
+	return netip.Addr{}, errors.New("no mapped address in the stun response")
+}
+
+// parseSTUNAddress decodes an address attribute, xor is the magic cookie and
+// transaction id for XOR-MAPPED-ADDRESS or nil for MAPPED-ADDRESS.
+func parseSTUNAddress(value, xor []byte) (netip.Addr, bool) {
+	if len(value) < 4 {
+		return netip.Addr{}, false
+	}
+
+	var size int
+	switch value[1] {
+	case stunFamilyIP4:
+		size = 4
+	case stunFamilyIP6:
+		size = 16
+	default:
+		return netip.Addr{}, false
+	}
+	if len(value) < 4+size {
+		return netip.Addr{}, false
+	}
+
+	raw := make([]byte, size)
+	copy(raw, value[4:4+size])
+	for i := range raw {
+		if xor != nil {
+			raw[i] ^= xor[i]
+		}
+	}
+	return netip.AddrFromSlice(raw)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ssdpAddr            = "239.255.255.250:1900"
+	ssdpSearchTimeout   = 3 * time.Second
+	upnpMaxResponseSize = 64 << 10
+)
+
+// upnpWANServices are the IGD services that answer GetExternalIPAddress.
+var upnpWANServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// upnpDevice is the part of the UPnP device description that lists the
+// services, devices nest in each other.
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// controlURL returns the control URL and the type of the first WAN connection
+// service of the device or of one of its embedded devices.
+func (d upnpDevice) controlURL() (string, string, bool) {
+	for _, s := range d.Services {
+		for _, t := range upnpWANServices {
+			if s.ServiceType == t {
+				return s.ControlURL, t, true
+			}
+		}
+	}
+	for _, child := range d.Devices {
+		if u, t, ok := child.controlURL(); ok {
+			return u, t, ok
+		}
+	}
+	return "", "", false
+}
+
+// getCurrentIPviaUPnP discovers the Internet Gateway Device with SSDP and asks
+// it for the external address of its WAN connection. Only IPv4 is supported.
+func getCurrentIPviaUPnP(ctx context.Context) (netip.Addr, error) {
+	location, err := ssdpDiscover(ctx)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	client := &http.Client{Timeout: ipProviderTimeout}
+	controlURL, serviceType, err := upnpControlURL(ctx, client, location)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	body := fmt.Sprintf(`<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:GetExternalIPAddress xmlns:u="%s"/></s:Body>
+</s:Envelope>`, serviceType)
+	req, err := http.NewRequestWithContext(ctx, "POST", controlURL, strings.NewReader(body))
+	if err != nil {
+		return netip.Addr{}, errors.Wrap(err, "could not create the upnp request")
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#GetExternalIPAddress"`, serviceType))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return netip.Addr{}, errors.Wrap(err, "upnp request failed")
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return netip.Addr{}, errors.Wrap(newHTTPStatusError(res), "upnp request failed")
+	}
+
+	var envelope struct {
+		Address string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(res.Body, upnpMaxResponseSize)).Decode(&envelope); err != nil {
+		return netip.Addr{}, errors.Wrap(err, "could not parse the upnp response")
+	}
+	ip, err := netip.ParseAddr(strings.TrimSpace(envelope.Address))
+	if err != nil {
+		return netip.Addr{}, errors.Wrap(err, "gateway returned an invalid external address")
+	}
+	if !isPublicAddr(ip) {
+		return netip.Addr{}, errors.Errorf("gateway external address %v is not public, the gateway is behind another NAT", ip)
+	}
+	return ip, nil
+}
+
+// ssdpDiscover sends an SSDP M-SEARCH and returns the description location of
+// the first gateway that answers.
+func ssdpDiscover(ctx context.Context) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", errors.Wrap(err, "could not open the ssdp socket")
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(ssdpSearchTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return "", errors.Wrap(err, "could not set the ssdp deadline")
+	}
+
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return "", errors.Wrap(err, "could not resolve the ssdp address")
+	}
+	for _, st := range upnpWANServices {
+		search := "M-SEARCH * HTTP/1.1\r\n" +
+			"HOST: " + ssdpAddr + "\r\n" +
+			"MAN: \"ssdp:discover\"\r\n" +
+			"MX: 2\r\n" +
+			"ST: " + st + "\r\n\r\n"
+		if _, err := conn.WriteTo([]byte(search), dst); err != nil {
+			return "", errors.Wrap(err, "could not send the ssdp search")
+		}
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", errors.Wrap(err, "no upnp gateway answered")
+		}
+		res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		res.Body.Close()
+		if location := res.Header.Get("Location"); location != "" {
+			return location, nil
+		}
+	}
+}
+
+// upnpControlURL fetches the device description at location and returns the
+// absolute control URL and the type of its WAN connection service.
+func upnpControlURL(ctx context.Context, client *http.Client, location string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+	if err != nil {
+		return "", "", errors.Wrap(err, "invalid upnp device location")
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", "", errors.Wrap(err, "could not fetch the upnp device description")
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return "", "", errors.Wrap(newHTTPStatusError(res), "could not fetch the upnp device description")
+	}
+
+	var root struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(res.Body, upnpMaxResponseSize)).Decode(&root); err != nil {
+		return "", "", errors.Wrap(err, "could not parse the upnp device description")
+	}
+	control, serviceType, ok := root.Device.controlURL()
+	if !ok {
+		return "", "", errors.New("the upnp device has no WAN connection service")
+	}
+
+	base := root.URLBase
+	if base == "" {
+		base = location
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", "", errors.Wrap(err, "invalid upnp base url")
+	}
+	controlURL, err := baseURL.Parse(control)
+	if err != nil {
+		return "", "", errors.Wrap(err, "invalid upnp control url")
+	}
+	return controlURL.String(), serviceType, nil
+}
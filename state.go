@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RecordState is the last known state of a DNS record.
+type RecordState struct {
+	IP string `json:"ip"`
+	// UpdatedAt is the time the IP last changed, zero when it's unknown, i.e.
+	// for a record first seen with its current IP.
+	UpdatedAt time.Time `json:"updated_at"`
+	// TTL and Proxied are the settings the record was written with, zero and
+	// nil when the ones of the record were kept.
+	TTL     int   `json:"ttl,omitempty"`
+	Proxied *bool `json:"proxied,omitempty"`
+}
+
+// sameSettings reports whether the state was written with the settings.
+func (s RecordState) sameSettings(ttl int, proxied *bool) bool {
+	if s.TTL != ttl || (s.Proxied == nil) != (proxied == nil) {
+		return false
+	}
+	return proxied == nil || *s.Proxied == *proxied
+}
+
+// StateStore holds the state of the records keyed by type and name.
+type StateStore struct {
+	mu sync.Mutex
+	// saveMu serializes the saves so that an older snapshot can't replace a
+	// newer one.
+	saveMu  sync.Mutex
+	Records map[string]RecordState
+}
+
+func newStateStore() *StateStore {
+	return &StateStore{Records: make(map[string]RecordState)}
+}
+
+// Load reads the state from path, a missing file leaves the state empty.
+func (s *StateStore) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not read the state file")
+	}
+
+	records := make(map[string]RecordState)
+	if err := json.Unmarshal(data, &records); err != nil {
+		return errors.Wrap(err, "could not parse the state file")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Records = records
+	return nil
+}
+
+// Save atomically writes the state to path by writing a temporary file next
+// to it and renaming it.
+func (s *StateStore) Save(path string) error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s.Records, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "could not encode the state")
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "could not create the state file")
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return errors.Wrap(err, "could not write the state file")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "could not write the state file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "could not write the state file")
+	}
+	return errors.Wrap(os.Rename(f.Name(), path), "could not replace the state file")
+}
+
+// Get returns the state of the record.
+func (s *StateStore) Get(key string) (RecordState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.Records[key]
+	return state, ok
+}
+
+// Set stores the state of the record and reports whether it changed.
+// UpdatedAt is set to changedAt when the address differs from the stored one,
+// as the stored one is the last known, and kept otherwise.
+func (s *StateStore) Set(key string, state RecordState, changedAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.Records[key]
+	switch {
+	case !ok:
+		state.UpdatedAt = changedAt
+	case old.IP != state.IP:
+		state.UpdatedAt = time.Now()
+	case old.sameSettings(state.TTL, state.Proxied):
+		return false
+	default:
+		state.UpdatedAt = old.UpdatedAt
+	}
+	s.Records[key] = state
+	return true
+}
+
+// lastKnownIP remembers the content last pushed to each record, optionally
+// persisted to a state file, so that unchanged records don't hit the
+// Cloudflare API.
+type lastKnownIP struct {
+	path  string
+	store *StateStore
+}
+
+func recordKey(recordType, name string) string {
+	return recordType + " " + name
+}
+
+// noChangeKey returns the key of the no change outcomes of the record written
+// with the writer.
+func noChangeKey(writer, recordType, name string) string {
+	return writer + " " + recordKey(recordType, name)
+}
+
+// loadLastKnownIP seeds the cache from the state file at path. An empty path
+// keeps the cache in memory only.
+func loadLastKnownIP(path string) (*lastKnownIP, error) {
+	c := &lastKnownIP{
+		path:  path,
+		store: newStateStore(),
+	}
+	if path == "" {
+		return c, nil
+	}
+	if err := c.store.Load(path); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Matches reports whether content was the last value pushed to the record
+// with the same TTL and proxied settings, so that a change of the settings
+// isn't skipped until the address changes.
+func (c *lastKnownIP) Matches(recordType, name, content string, ttl int, proxied *bool) bool {
+	if c == nil {
+		return false
+	}
+	state, ok := c.store.Get(recordKey(recordType, name))
+	return ok && sameContent(recordType, state.IP, content) && state.sameSettings(ttl, proxied)
+}
+
+// LastChange returns the content last pushed to the record and when it was
+// changed to it, the zero time when that's unknown.
+func (c *lastKnownIP) LastChange(recordType, name string) (string, time.Time, bool) {
+	if c == nil {
+		return "", time.Time{}, false
+	}
+	state, ok := c.store.Get(recordKey(recordType, name))
+	return state.IP, state.UpdatedAt, ok
+}
+
+// Set records content and the settings as the current state of the record and
+// persists the state. changed tells whether the content of the record was
+// just changed, otherwise the time of the change of a record first seen is
+// unknown.
+func (c *lastKnownIP) Set(recordType, name, content string, ttl int, proxied *bool, changed bool) error {
+	if c == nil {
+		return nil
+	}
+	var changedAt time.Time
+	if changed {
+		changedAt = time.Now()
+	}
+	changed = c.store.Set(recordKey(recordType, name), RecordState{IP: content, TTL: ttl, Proxied: proxied}, changedAt)
+	if !changed || c.path == "" {
+		return nil
+	}
+	return c.store.Save(c.path)
+}
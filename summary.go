@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// updateSummary counts the outcomes of the record updates of a cycle for the
+// RESULT line. It's nil safe.
+type updateSummary struct {
+	mu       sync.Mutex
+	Changed  int
+	NoChange int
+	Errors   int
+	IP4, IP6 netip.Addr
+}
+
+// add counts the outcome of one record.
+func (s *updateSummary) add(changed bool, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err != nil:
+		s.Errors++
+	case changed:
+		s.Changed++
+	default:
+		s.NoChange++
+	}
+}
+
+// setIP remembers the address the records of its family were pointed at.
+func (s *updateSummary) setIP(ip netip.Addr) {
+	if s == nil || !ip.IsValid() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ip.Is4() {
+		s.IP4 = ip
+	} else {
+		s.IP6 = ip
+	}
+}
+
+// String returns the stable machine-readable summary, i.e.
+// "RESULT changed=1 nochange=2 errors=0 ip4=203.0.113.5".
+func (s *updateSummary) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "RESULT changed=%d nochange=%d errors=%d", s.Changed, s.NoChange, s.Errors)
+	if s.IP4.IsValid() {
+		fmt.Fprintf(&b, " ip4=%v", s.IP4)
+	}
+	if s.IP6.IsValid() {
+		fmt.Fprintf(&b, " ip6=%v", s.IP6)
+	}
+	return b.String()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// applyContentWithProxyToggle sets the proxied record to DNS only, updates its
+// content and then proxies it again, i.e. for maintenance that needs the
+// origin to be reachable directly while the address changes. The records that
+// aren't proxied or whose content is up to date are updated as they are.
+func applyContentWithProxyToggle(ctx context.Context, api dnsAPI, zoneID string, record cloudflare.DNSRecord, content string, opts updateOptions) (applyOutcome, error) {
+	// The records that are to be DNS only are left so.
+	restore := record.Proxied != nil && *record.Proxied && (opts.Proxied == nil || *opts.Proxied)
+	if !restore || sameContent(record.Type, record.Content, content) {
+		return applyContent(ctx, api, zoneID, record, content, opts)
+	}
+
+	// The checks, the confirmation and the pre hook come before the proxy is
+	// turned off, a change that isn't made leaves the record alone.
+	dnsOnly := opts
+	dnsOnly.Proxied = cloudflare.BoolPtr(false)
+	params, outcome, err := prepareChange(ctx, record, content, dnsOnly)
+	if err != nil || outcome != applyChanged {
+		if outcome == applyDryRun {
+			logFrom(ctx).WithFields(logrus.Fields{
+				"event":   "record_would_toggle_proxy",
+				"name":    record.Name,
+				"type":    record.Type,
+				"content": content,
+			}).Infof("would set %s to DNS only, update it to %s and proxy it again", record.Name, content)
+		}
+		return outcome, err
+	}
+
+	if err := setProxied(ctx, api, zoneID, record, false); err != nil {
+		return applyUnchanged, errors.Wrapf(err, "could not set %s record %s to DNS only, it's unchanged", record.Type, record.Name)
+	}
+	record.Proxied = cloudflare.BoolPtr(false)
+
+	outcome, updateErr := writeChange(ctx, api, zoneID, record, params, dnsOnly)
+	if outcome == applyChanged {
+		record.Content = content
+	}
+
+	if err := setProxied(ctx, api, zoneID, record, true); err != nil {
+		logFrom(ctx).WithError(err).WithFields(logrus.Fields{
+			"event": "record_proxy_restore_failed",
+			"name":  record.Name,
+			"type":  record.Type,
+		}).Error("the record is left DNS only, proxy it again in the dashboard")
+		return outcome, errors.Wrapf(err, "%s record %s is left DNS only (not proxied), could not restore the proxied state", record.Type, record.Name)
+	}
+	return outcome, errors.Wrapf(updateErr, "the proxied state of %s record %s was restored but the update failed", record.Type, record.Name)
+}
+
+// setProxied changes only the proxied state of the record. The proxied
+// records get the automatic TTL, the one Cloudflare uses for them anyway.
+func setProxied(ctx context.Context, api dnsAPI, zoneID string, record cloudflare.DNSRecord, proxied bool) error {
+	ttl := record.TTL
+	if proxied {
+		ttl = defaultTTL
+	}
+	err := withRetry(ctx, defaultRetryPolicy, func() error {
+		_, err := api.UpdateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.UpdateDNSRecordParams{
+			ID:      record.ID,
+			Name:    record.Name,
+			Type:    record.Type,
+			Content: record.Content,
+			TTL:     ttl,
+			Proxied: &proxied,
+			Tags:    record.Tags,
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	logFrom(ctx).WithFields(logrus.Fields{
+		"event":   "record_proxy_toggled",
+		"name":    record.Name,
+		"type":    record.Type,
+		"proxied": proxied,
+	}).Info("changed the proxied state of the record")
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/urfave/cli/v2"
+)
+
+// cloudflareModule is the path of the Cloudflare SDK, its version is reported
+// with the build info.
+const cloudflareModule = "github.com/cloudflare/cloudflare-go"
+
+// buildInfo describes the running build for the bug reports.
+type buildInfo struct {
+	Version    string
+	Commit     string
+	Date       string
+	GoVersion  string
+	Cloudflare string
+}
+
+// readBuildInfo returns the build info set with -ldflags, the values that
+// weren't set are taken from the info embedded by the go toolchain.
+func readBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:    version,
+		Commit:     commit,
+		Date:       date,
+		GoVersion:  runtime.Version(),
+		Cloudflare: "unknown",
+	}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch {
+		case s.Key == "vcs.revision" && info.Commit == "none":
+			info.Commit = s.Value
+		case s.Key == "vcs.time" && info.Date == "unknown":
+			info.Date = s.Value
+		}
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path == cloudflareModule {
+			info.Cloudflare = dep.Version
+		}
+	}
+	return info
+}
+
+// String returns the one line version of the app, i.e. for --version.
+func (b buildInfo) String() string {
+	return fmt.Sprintf("%v, commit %v, built at %v", b.Version, b.Commit, b.Date)
+}
+
+// Version will print the build info.
+func Version(c *cli.Context) error {
+	info := readBuildInfo()
+	fmt.Fprintf(c.App.Writer, "version:       %s\n", info.Version)
+	fmt.Fprintf(c.App.Writer, "commit:        %s\n", info.Commit)
+	fmt.Fprintf(c.App.Writer, "built at:      %s\n", info.Date)
+	fmt.Fprintf(c.App.Writer, "go:            %s %s/%s\n", info.GoVersion, runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(c.App.Writer, "cloudflare-go: %s\n", info.Cloudflare)
+	return nil
+}
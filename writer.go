@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/pkg/errors"
+)
+
+// RecordWriter points the record of the zone at the content, i.e. at a DNS
+// provider. The zone is given by name, the same zone may have different IDs
+// with different providers.
+type RecordWriter interface {
+	// Name identifies the writer in the errors, i.e. "cloudflare".
+	Name() string
+	WriteRecord(ctx context.Context, zone, domainName, recordType, content string, opts updateOptions) (UpdateResult, error)
+	// ReconcileRecords keeps a record of the name and type for each of the
+	// contents, i.e. for the round robin records.
+	ReconcileRecords(ctx context.Context, zone, domainName, recordType string, contents []string, opts updateOptions) (UpdateResult, error)
+	// PruneRecords deletes the records of the zone that prune selects and
+	// returns the recordKey of the deleted ones.
+	PruneRecords(ctx context.Context, zone string, prune func(cloudflare.DNSRecord) bool, opts updateOptions) (map[string]bool, error)
+}
+
+// primaryWriter is the name of the writer of the records to the Cloudflare
+// account of the credentials.
+const primaryWriter = "cloudflare"
+
+// cloudflareWriter writes the records with the Cloudflare API. The zone name
+// is resolved with zones unless zoneID is set.
+type cloudflareWriter struct {
+	name   string
+	api    dnsAPI
+	zones  *zoneResolver
+	zoneID string
+}
+
+func (w cloudflareWriter) Name() string {
+	return w.name
+}
+
+func (w cloudflareWriter) WriteRecord(ctx context.Context, zone, domainName, recordType, content string, opts updateOptions) (UpdateResult, error) {
+	zoneID, err := w.resolve(ctx, zone)
+	if err != nil {
+		return UpdateResult{}, err
+	}
+	return updateRecord(ctx, w.api, zoneID, domainName, recordType, content, opts)
+}
+
+func (w cloudflareWriter) ReconcileRecords(ctx context.Context, zone, domainName, recordType string, contents []string, opts updateOptions) (UpdateResult, error) {
+	zoneID, err := w.resolve(ctx, zone)
+	if err != nil {
+		return UpdateResult{}, err
+	}
+	return reconcileRecords(ctx, w.api, zoneID, domainName, recordType, contents, opts)
+}
+
+func (w cloudflareWriter) PruneRecords(ctx context.Context, zone string, prune func(cloudflare.DNSRecord) bool, opts updateOptions) (map[string]bool, error) {
+	zoneID, err := w.resolve(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	return pruneRecords(ctx, w.api, zoneID, prune, opts)
+}
+
+// resolve returns the ID of the zone with the writer.
+func (w cloudflareWriter) resolve(ctx context.Context, zone string) (string, error) {
+	if w.zoneID != "" {
+		return w.zoneID, nil
+	}
+	if zone == "" {
+		return "", errors.New("the zone is only known by its id, set the zone name to write it to another account")
+	}
+	return w.zones.Resolve(ctx, zone)
+}
+
+// secondaryWriter is an additional writer with the addresses it was last
+// given, kept apart from the ones of Cloudflare so that a failed write is
+// retried on the next cycle.
+type secondaryWriter struct {
+	RecordWriter
+	cache *lastKnownIP
+}
+
+func newSecondaryWriter(w RecordWriter) secondaryWriter {
+	return secondaryWriter{RecordWriter: w, cache: &lastKnownIP{store: newStateStore()}}
+}
+
+// options returns opts for the writes of the secondary writer.
+func (w secondaryWriter) options(opts updateOptions) updateOptions {
+	// The hooks, the notifications and the confirmations are about the
+	// change, they're only made once for the primary writer.
+	opts.Hooks = hookCommands{}
+	opts.Notifier = nil
+	opts.Confirm = nil
+	// The public DNS only tells about the primary writer.
+	opts.VerifyDNS = false
+	// The record IDs are the ones of the primary writer.
+	opts.RecordID = ""
+	// The outcomes are counted once for the record.
+	opts.Summary = nil
+	opts.Cache = w.cache
+	opts.Writer = w.Name()
+	return opts
+}
+
+// writeRecord writes the record with the primary writer and then with each of
+// the secondary ones.
+func writeRecord(ctx context.Context, primary RecordWriter, zone, domainName, recordType, content string, opts updateOptions) (UpdateResult, error) {
+	return fanOut(ctx, primary, opts, func(ctx context.Context, w RecordWriter, opts updateOptions) (UpdateResult, error) {
+		return w.WriteRecord(ctx, zone, domainName, recordType, content, opts)
+	})
+}
+
+// fanOut makes the change with the primary writer and then, unless it failed
+// or was declined, with each of the secondary ones. The result is the one of
+// the primary writer, the errors of all of them are returned together.
+func fanOut(ctx context.Context, primary RecordWriter, opts updateOptions, write func(ctx context.Context, w RecordWriter, opts updateOptions) (UpdateResult, error)) (UpdateResult, error) {
+	primaryOpts := opts
+	primaryOpts.Writer = primary.Name()
+	result, err := write(ctx, primary, primaryOpts)
+	if len(opts.Writers) == 0 {
+		return result, err
+	}
+	// The secondary writers only follow the changes the primary one made or
+	// would make, not the ones it refused.
+	if err != nil || result.Declined {
+		logFrom(ctx).WithField("event", "writers_skipped").Debug("not writing the change with the other writers")
+		return result, err
+	}
+
+	var errs []error
+	for _, w := range opts.Writers {
+		ctx := withLog(ctx, logFrom(ctx).WithField("writer", w.Name()))
+		if _, err := write(ctx, w, w.options(opts)); err != nil {
+			errs = append(errs, errors.Wrapf(err, "writer %s", w.Name()))
+		}
+	}
+	return result, stderrors.Join(errs...)
+}
+
+// writers returns the additional writers of the records.
+func (cfg *Config) writers(opts ...cloudflare.Option) ([]secondaryWriter, error) {
+	var writers []secondaryWriter
+	for i, w := range cfg.Writers {
+		if w.Type != "cloudflare" {
+			return nil, errors.Errorf("invalid writers[%d].type %q, only cloudflare is supported", i, w.Type)
+		}
+		api, err := newCloudflareClient(authConfig{Token: w.Token}, opts...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "writers[%d]", i)
+		}
+		writers = append(writers, newSecondaryWriter(cloudflareWriter{
+			name:  fmt.Sprintf("cloudflare[%d]", i),
+			api:   api,
+			zones: newZoneResolver(api),
+		}))
+	}
+	return writers, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+)
+
+func TestWriteRecordFanOut(t *testing.T) {
+	tests := []struct {
+		name    string
+		primary *fakeDNS
+		confirm string
+		want    int
+	}{
+		{
+			name:    "changed",
+			primary: &fakeDNS{records: []cloudflare.DNSRecord{aRecord("1", "198.51.100.1")}},
+			want:    1,
+		},
+		{
+			name:    "unchanged",
+			primary: &fakeDNS{records: []cloudflare.DNSRecord{aRecord("1", "203.0.113.7")}},
+			want:    1,
+		},
+		{
+			name:    "failed",
+			primary: &fakeDNS{},
+		},
+		{
+			name:    "declined",
+			primary: &fakeDNS{records: []cloudflare.DNSRecord{aRecord("1", "198.51.100.1")}},
+			confirm: "n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backup := &fakeDNS{records: []cloudflare.DNSRecord{aRecord("b", "198.51.100.1")}}
+			opts := updateOptions{
+				Writers: []secondaryWriter{newSecondaryWriter(cloudflareWriter{name: "backup", api: backup, zoneID: "backup"})},
+			}
+			if tt.confirm != "" {
+				opts.Confirm = &confirmer{in: bufio.NewReader(strings.NewReader(tt.confirm)), out: io.Discard}
+			}
+			primary := cloudflareWriter{name: primaryWriter, api: tt.primary, zoneID: "zone"}
+			writeRecord(context.Background(), primary, "example.com", "home.example.com", "A", "203.0.113.7", opts)
+			if len(backup.updates) != tt.want {
+				t.Errorf("the backup writer made %d updates, want %d", len(backup.updates), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// zoneLister is the part of the Cloudflare API the zones are looked up with,
+// it's implemented by *cloudflare.API.
+type zoneLister interface {
+	ListZonesContext(ctx context.Context, opts ...cloudflare.ReqOption) (cloudflare.ZonesResponse, error)
+}
+
+// zoneResolver caches the zone IDs by zone name so that they are only looked
+// up once.
+type zoneResolver struct {
+	api zoneLister
+	// wait keeps looking up a zone that isn't found for this long, i.e. right
+	// after it was created and before it has propagated.
+	wait time.Duration
+
+	mu  sync.Mutex
+	ids map[string]string
+}
+
+func newZoneResolver(api zoneLister) *zoneResolver {
+	return &zoneResolver{
+		api: api,
+		ids: make(map[string]string),
+	}
+}
+
+// Resolve returns the ID of the zone with the given name.
+func (r *zoneResolver) Resolve(ctx context.Context, name string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id, ok := r.ids[name]; ok {
+		return id, nil
+	}
+
+	deadline := time.Now().Add(r.wait)
+	for {
+		id, err := r.lookup(ctx, name)
+		if err == nil {
+			r.ids[name] = id
+			return id, nil
+		}
+		if !errors.Is(err, ErrZoneNotFound) || time.Now().Add(zoneWaitDelay).After(deadline) {
+			return "", err
+		}
+
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"event": "zone_wait",
+			"zone":  name,
+			"delay": zoneWaitDelay,
+		}).Info("zone not found yet, waiting for it")
+		t := time.NewTimer(zoneWaitDelay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return "", errors.Wrap(ctx.Err(), err.Error())
+		case <-t.C:
+		}
+	}
+}
+
+// zoneWaitDelay is the pause between the lookups of a zone that isn't found.
+const zoneWaitDelay = 2 * time.Second
+
+// lookup finds the ID of the zone with the given name with the API.
+func (r *zoneResolver) lookup(ctx context.Context, name string) (string, error) {
+	// ZoneIDByName doesn't take a context, so the lookup couldn't be cancelled.
+	var res cloudflare.ZonesResponse
+	err := withRetry(ctx, defaultRetryPolicy, func() error {
+		var err error
+		res, err = r.api.ListZonesContext(ctx, cloudflare.WithZoneFilters(name, "", ""))
+		return err
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "could not find zone by name")
+	}
+	switch len(res.Result) {
+	case 0:
+		return "", errors.Wrapf(ErrZoneNotFound, "no zone %s", name)
+	case 1:
+	default:
+		return "", errors.Errorf("ambiguous zone name, %d zones named %s", len(res.Result), name)
+	}
+	id := res.Result[0].ID
+	logrus.WithFields(logrus.Fields{
+		"event": "zone_resolved",
+		"zone":  name,
+		"id":    id,
+	}).Debug("resolved zone")
+	return id, nil
+}
+
+// InvalidateOnError drops the cached zone ID if err indicates that the zone
+// no longer exists, so that it's looked up again next time.
+func (r *zoneResolver) InvalidateOnError(name string, err error) {
+	var notFound *cloudflare.NotFoundError
+	if !errors.As(err, &notFound) {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.ids, name)
+}